@@ -0,0 +1,401 @@
+// Package bridge relays events between the channels of chat networks.
+//
+// Each network is adapted to an Endpoint,
+// which reports the events in its channel in a common form
+// and renders the events of other endpoints for its channel.
+// A Bridge relays events between two endpoints,
+// translating references to messages between them,
+// and falling back to plain notices
+// for events that an endpoint does not support.
+// Events for an endpoint that cannot reach its network
+// are queued and sent once it can.
+package bridge
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxIDs is the number of message IDs a bridge remembers
+// if it has no IDStore.
+const maxIDs = 2000
+
+// An Endpoint is a channel on a chat network.
+type Endpoint interface {
+	// Connect joins the channel and starts reporting its events.
+	Connect() error
+
+	// Events returns the channel of the endpoint's events.
+	// It is closed if the endpoint's connection fails.
+	Events() <-chan Event
+
+	// Send renders the event in the channel.
+	// It returns the ID of the resulting message,
+	// or the empty string if it cannot be referred to later.
+	Send(Event) (string, error)
+
+	// Capabilities returns the kinds of events that Send supports natively.
+	// Others are sent as notices.
+	Capabilities() Capabilities
+
+	// Close stops reporting events and leaves the channel.
+	Close() error
+}
+
+// Capabilities are the kinds of events that an endpoint can render natively.
+type Capabilities struct {
+	// Edit is whether the endpoint can edit its messages.
+	Edit bool
+	// Delete is whether the endpoint can delete its messages.
+	Delete bool
+	// Reaction is whether the endpoint can react to its messages.
+	Reaction bool
+	// Topic is whether the endpoint can set the channel topic.
+	Topic bool
+	// Reply is whether the endpoint can reply to its messages.
+	// Otherwise, replies quote the message they reply to.
+	Reply bool
+	// Coalesce, if positive, is how long after a message
+	// the endpoint waits for more messages from its sender
+	// to send with it as one message.
+	Coalesce time.Duration
+}
+
+// An IDStore records the IDs of messages relayed between endpoints.
+type IDStore interface {
+	// Put records that the message with the given key
+	// was relayed as, or from, the message with ID id.
+	Put(key, id string) error
+	// Get returns the ID recorded for the message with the given key,
+	// and whether there is one.
+	Get(key string) (id string, ok bool, err error)
+}
+
+// A Bridge relays events between endpoints A and B.
+type Bridge struct {
+	// Name names the bridge in the keys of its IDs.
+	// Bridges sharing an IDStore must have different names.
+	Name string
+
+	A, B Endpoint
+
+	// Filter, if non-nil, reports whether to relay an event
+	// from the endpoint from to the other endpoint.
+	Filter func(from Endpoint, e Event) bool
+
+	// IDs, if non-nil, records the IDs of relayed messages.
+	// Otherwise, the IDs of recent messages are kept in memory.
+	IDs IDStore
+
+	// Queues, if non-nil, stores the events awaiting delivery
+	// to an unavailable endpoint.
+	// Otherwise, they are queued in memory.
+	// Bridges sharing a QueueStore must have different names.
+	Queues QueueStore
+	// QueueLen is the maximum number of events queued for each endpoint,
+	// or DefaultQueueLen if zero.
+	// When a queue is full, its oldest event is dropped.
+	QueueLen int
+	// QueueAge is the maximum age of a queued event,
+	// or DefaultQueueAge if zero.
+	// Older events are dropped.
+	QueueAge time.Duration
+	// Retry is the interval between attempts to send queued events,
+	// or DefaultRetry if zero.
+	Retry time.Duration
+
+	// BufferLen is the maximum number of events from each endpoint
+	// awaiting relay to the other, or DefaultBufferLen if zero.
+	BufferLen int
+	// Overflow is what to do with an event from an endpoint
+	// whose buffer is full.
+	Overflow Overflow
+
+	mu sync.Mutex
+	// fromA and fromB are the buffers of the events from A and B.
+	fromA, fromB *buffer
+}
+
+// Run connects both endpoints and relays events between them
+// until either endpoint's events channel is closed.
+// Events queued by an earlier run are sent first.
+//
+// Each endpoint's events are read into a buffer
+// and sent to the other endpoint from there,
+// so an endpoint that is slow to send
+// does not hold up the other endpoint or the other direction.
+func (b *Bridge) Run() error {
+	if err := b.A.Connect(); err != nil {
+		return err
+	}
+	defer b.A.Close()
+	if err := b.B.Connect(); err != nil {
+		return err
+	}
+	defer b.B.Close()
+
+	b.setDefaults()
+	toA, err := b.outbox(b.A)
+	if err != nil {
+		return err
+	}
+	toB, err := b.outbox(b.B)
+	if err != nil {
+		return err
+	}
+	fromA, fromB := newBuffer(b.BufferLen, b.Overflow), newBuffer(b.BufferLen, b.Overflow)
+	b.mu.Lock()
+	b.fromA, b.fromB = fromA, fromB
+	b.mu.Unlock()
+
+	stop := make(chan struct{})
+	errc := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() { defer wg.Done(); b.read(b.A, fromA, stop, errc) }()
+	go func() { defer wg.Done(); b.read(b.B, fromB, stop, errc) }()
+	go func() { defer wg.Done(); b.write(b.A, fromA, toB, stop) }()
+	go func() { defer wg.Done(); b.write(b.B, fromB, toA, stop) }()
+	err = <-errc
+	close(stop)
+	wg.Wait()
+	return err
+}
+
+// Stats returns the overflow counts of the buffers
+// of the events from A and from B.
+func (b *Bridge) Stats() (fromA, fromB Stats) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fromA == nil {
+		return Stats{}, Stats{}
+	}
+	return b.fromA.Stats(), b.fromB.Stats()
+}
+
+// setDefaults sets the bridge's unset fields to their defaults.
+func (b *Bridge) setDefaults() {
+	if b.IDs == nil {
+		b.IDs = newMemIDs(maxIDs)
+	}
+	if b.Queues == nil {
+		b.Queues = make(memQueues)
+	}
+	if b.QueueLen == 0 {
+		b.QueueLen = DefaultQueueLen
+	}
+	if b.QueueAge == 0 {
+		b.QueueAge = DefaultQueueAge
+	}
+	if b.Retry == 0 {
+		b.Retry = DefaultRetry
+	}
+	if b.BufferLen == 0 {
+		b.BufferLen = DefaultBufferLen
+	}
+}
+
+// read puts the events from src that pass the bridge's filter in buf,
+// until src's events channel is closed, which it reports on errc,
+// or until stop is closed.
+func (b *Bridge) read(src Endpoint, buf *buffer, stop <-chan struct{}, errc chan<- error) {
+	events := src.Events()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				errc <- fmt.Errorf("%v disconnected", src)
+				return
+			}
+			if b.Filter == nil || b.Filter(src, e) {
+				buf.put(e, stop)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// write delivers the events from src in buf to the outbox's endpoint,
+// coalescing messages if the endpoint asks,
+// and retries the outbox's queued events, until stop is closed.
+// When retrying, it logs buf's overflow counts if they have changed.
+func (b *Bridge) write(src Endpoint, buf *buffer, o *outbox, stop <-chan struct{}) {
+	b.replay(src, o)
+	retry := time.NewTicker(b.Retry)
+	defer retry.Stop()
+	c := &coalescer{window: o.dst.Capabilities().Coalesce}
+	defer func() {
+		for _, e := range c.flush() {
+			b.deliver(src, o, e)
+		}
+	}()
+	var logged Stats
+	for {
+		select {
+		case <-buf.ready:
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				e, ok := buf.take()
+				if !ok {
+					break
+				}
+				for _, e := range c.add(e) {
+					b.deliver(src, o, e)
+				}
+			}
+		case <-c.due():
+			for _, e := range c.flush() {
+				b.deliver(src, o, e)
+			}
+		case <-retry.C:
+			b.replay(src, o)
+			if s := buf.Stats(); s != logged {
+				log.Printf("bridge %s has dropped %d and coalesced %d events from %v", b.Name, s.Dropped, s.Coalesced, src)
+				logged = s
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// send sends an event from src to dst, and records the ID it is sent as.
+// It returns an error only if dst is unavailable;
+// other errors are logged, and the event is dropped.
+func (b *Bridge) send(src, dst Endpoint, e Event) error {
+	e, ok := b.translate(src, dst, e)
+	if !ok {
+		return nil
+	}
+	id, err := dst.Send(e)
+	if err != nil {
+		log.Printf("%v failed to send %v: %v", dst, e.Kind, err)
+		if _, ok := err.(UnavailableError); ok {
+			return err
+		}
+		return nil
+	}
+	if e.ID != "" && id != "" {
+		// Each message coalesced into e refers to the message sent,
+		// which refers back to the last of them.
+		for _, p := range parts(e) {
+			if p.ID == "" {
+				continue
+			}
+			if err := b.IDs.Put(b.key(src, p.ID), id); err != nil {
+				log.Printf("bridge %s failed to record ID: %v", b.Name, err)
+			}
+		}
+		if err := b.IDs.Put(b.key(dst, id), e.ID); err != nil {
+			log.Printf("bridge %s failed to record ID: %v", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// key returns the key of message id on endpoint ep in the bridge's IDs.
+func (b *Bridge) key(ep Endpoint, id string) string {
+	side := "a"
+	if ep == b.B {
+		side = "b"
+	}
+	return b.Name + "\x00" + side + "\x00" + id
+}
+
+// id returns the ID on the other endpoint of message id on endpoint ep.
+func (b *Bridge) id(ep Endpoint, id string) (string, bool) {
+	other, ok, err := b.IDs.Get(b.key(ep, id))
+	if err != nil {
+		log.Printf("bridge %s failed to look up ID: %v", b.Name, err)
+	}
+	return other, ok
+}
+
+// translate returns the event from src as it is sent to dst:
+// references to messages are translated to dst's IDs,
+// and events that dst cannot render natively become notices.
+// It returns false if the event is not relayed.
+func (b *Bridge) translate(src, dst Endpoint, e Event) (Event, bool) {
+	caps := dst.Capabilities()
+	if e.ReplyTo != nil && e.ReplyTo.ID != "" {
+		id, ok := b.id(src, e.ReplyTo.ID)
+		if !ok || !caps.Reply {
+			id = ""
+		}
+		r := *e.ReplyTo
+		r.ID = id
+		e.ReplyTo = &r
+	}
+	var native bool
+	switch e.Kind {
+	case Edit:
+		native = caps.Edit
+	case Delete:
+		native = caps.Delete
+	case Reaction:
+		native = caps.Reaction
+	case Topic:
+		if caps.Topic {
+			return e, true
+		}
+		return notice(e, Render(e)), true
+	default:
+		return e, true
+	}
+	if native {
+		if id, ok := b.id(src, e.Target.ID); ok {
+			e.Target.ID = id
+			return e, true
+		}
+	}
+	if e.Kind == Delete && e.Target.Text == "" {
+		return e, false
+	}
+	return notice(e, Render(e)), true
+}
+
+// notice returns a notice with the given text about event e.
+func notice(e Event, text string) Event {
+	return Event{Kind: Notice, Network: e.Network, Time: e.Time, From: e.From, Text: text}
+}
+
+// memIDs is an IDStore in memory.
+// It remembers a bounded number of the most recent IDs.
+type memIDs struct {
+	sync.Mutex
+	n     int
+	m     map[string]string
+	order []string
+}
+
+func newMemIDs(n int) *memIDs {
+	return &memIDs{n: n, m: make(map[string]string)}
+}
+
+func (s *memIDs) Put(key, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.m[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.m[key] = id
+	for len(s.order) > s.n {
+		delete(s.m, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+func (s *memIDs) Get(key string) (string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	id, ok := s.m[key]
+	return id, ok, nil
+}
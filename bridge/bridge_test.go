@@ -0,0 +1,228 @@
+package bridge
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A fakeEndpoint records the events sent to it.
+type fakeEndpoint struct {
+	name   string
+	caps   Capabilities
+	events chan Event
+	sent   chan Event
+	n      int
+	// down is whether Send fails as if the endpoint were unreachable.
+	down bool
+}
+
+func newFakeEndpoint(name string, caps Capabilities) *fakeEndpoint {
+	return &fakeEndpoint{name: name, caps: caps, events: make(chan Event), sent: make(chan Event, 10)}
+}
+
+func (f *fakeEndpoint) String() string             { return f.name }
+func (f *fakeEndpoint) Connect() error             { return nil }
+func (f *fakeEndpoint) Events() <-chan Event       { return f.events }
+func (f *fakeEndpoint) Capabilities() Capabilities { return f.caps }
+func (f *fakeEndpoint) Close() error               { return nil }
+
+func (f *fakeEndpoint) Send(e Event) (string, error) {
+	if f.down {
+		return "", UnavailableError{Err: errors.New("down")}
+	}
+	f.sent <- e
+	if e.Kind != Message {
+		return "", nil
+	}
+	f.n++
+	return f.name + strconv.Itoa(f.n), nil
+}
+
+func TestBridge(t *testing.T) {
+	a := newFakeEndpoint("a", Capabilities{})
+	b := newFakeEndpoint("b", Capabilities{Edit: true, Delete: true})
+	br := &Bridge{A: a, B: b, Filter: func(from Endpoint, e Event) bool {
+		return e.Kind != Topic || from == a
+	}}
+	errc := make(chan error, 1)
+	go func() { errc <- br.Run() }()
+
+	tests := []struct {
+		from, to *fakeEndpoint
+		event    Event
+		// want is the event sent, or the zero Event if none is.
+		want Event
+	}{
+		{a, b, Event{Kind: Message, ID: "1", From: "alice", Text: "teh"}, Event{Kind: Message, ID: "1", From: "alice", Text: "teh"}},
+		{a, b, Event{Kind: Edit, From: "alice", Target: Ref{ID: "1"}, Text: "the"}, Event{Kind: Edit, From: "alice", Target: Ref{ID: "b1"}, Text: "the"}},
+		{a, b, Event{Kind: Edit, From: "alice", Target: Ref{ID: "2"}, Text: "unknown"}, Event{Kind: Notice, From: "alice", Text: "* alice meant: unknown"}},
+		{b, a, Event{Kind: Edit, From: "bob", Target: Ref{ID: "b1"}, Text: "hi"}, Event{Kind: Notice, From: "bob", Text: "* bob meant: hi"}},
+		{b, a, Event{Kind: Delete, From: "bob", Target: Ref{ID: "b1", Text: "hi"}}, Event{Kind: Notice, From: "bob", Text: "* bob deleted 'hi'"}},
+		{b, a, Event{Kind: Message, Text: "yes", ReplyTo: &Ref{ID: "b1", From: "alice", Text: "the"}}, Event{Kind: Message, Text: "yes", ReplyTo: &Ref{From: "alice", Text: "the"}}},
+		{a, b, Event{Kind: Message, ID: "3", Text: "no", ReplyTo: &Ref{ID: "1"}}, Event{Kind: Message, ID: "3", Text: "no", ReplyTo: &Ref{}}},
+		{b, a, Event{Kind: Reaction, Target: Ref{ID: "b1"}, Text: "bob reacted :+1:"}, Event{Kind: Notice, Text: "bob reacted :+1:"}},
+		{a, b, Event{Kind: Topic, From: "alice", Text: "news"}, Event{Kind: Notice, From: "alice", Text: "alice changed the topic to: news"}},
+		{b, a, Event{Kind: Topic, From: "bob", Text: "olds"}, Event{}},
+		{b, a, Event{Kind: Message, Text: "done"}, Event{Kind: Message, Text: "done"}},
+	}
+	for _, test := range tests {
+		test.from.events <- test.event
+		if reflect.DeepEqual(test.want, Event{}) {
+			continue
+		}
+		select {
+		case got := <-test.to.sent:
+			// Events without a time are given the time they are relayed.
+			if got.Time.IsZero() {
+				t.Errorf("%s sent %+v to %s without a time", test.from, test.event, test.to)
+			}
+			got.Time = time.Time{}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s sent %+v to %s, got %+v, want %+v", test.from, test.event, test.to, got, test.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %+v", test.want)
+		}
+	}
+
+	close(a.events)
+	if err := <-errc; err == nil || err.Error() != "a disconnected" {
+		t.Errorf("Run()=%v, want a disconnected", err)
+	}
+}
+
+func TestBridgeCoalesced(t *testing.T) {
+	a := newFakeEndpoint("a", Capabilities{})
+	b := newFakeEndpoint("b", Capabilities{Edit: true, Coalesce: time.Hour})
+	br := &Bridge{A: a, B: b}
+	errc := make(chan error, 1)
+	go func() { errc <- br.Run() }()
+
+	// Corrections of any line of a coalesced message refer to the message sent.
+	a.events <- Event{Kind: Message, ID: "1", From: "alice", Text: "teh"}
+	a.events <- Event{Kind: Message, ID: "2", From: "alice", Text: "cat"}
+	a.events <- Event{Kind: Edit, From: "alice", Target: Ref{ID: "1", Text: "teh"}, Text: "the"}
+	a.events <- Event{Kind: Edit, From: "alice", Target: Ref{ID: "2", Text: "cat"}, Text: "dog"}
+	want := []Event{
+		{Kind: Message, ID: "2", From: "alice", Text: "teh\ncat", Parts: []Ref{
+			{ID: "1", From: "alice", Text: "teh"},
+			{ID: "2", From: "alice", Text: "cat"},
+		}},
+		{Kind: Edit, From: "alice", Target: Ref{ID: "b1", Text: "teh"}, Text: "the"},
+		{Kind: Edit, From: "alice", Target: Ref{ID: "b1", Text: "cat"}, Text: "dog"},
+	}
+	for _, w := range want {
+		select {
+		case got := <-b.sent:
+			got.Time = time.Time{}
+			if !reflect.DeepEqual(got, w) {
+				t.Errorf("sent %+v, want %+v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+
+	close(a.events)
+	<-errc
+}
+
+func TestBridgeQueue(t *testing.T) {
+	a := newFakeEndpoint("a", Capabilities{})
+	b := newFakeEndpoint("b", Capabilities{Edit: true})
+	br := &Bridge{A: a, B: b, QueueLen: 2, QueueAge: time.Hour}
+	br.setDefaults()
+	toB, err := br.outbox(b)
+	if err != nil {
+		t.Fatalf("outbox failed: %v", err)
+	}
+	sent := func() []Event {
+		var es []Event
+		for len(b.sent) > 0 {
+			es = append(es, <-b.sent)
+		}
+		return es
+	}
+
+	then := time.Now().Add(-time.Minute)
+	stamp := "[" + then.UTC().Format("Jan 2 15:04 MST") + "] "
+	b.down = true
+	br.deliver(a, toB, Event{Kind: Message, ID: "1", Time: then.Add(-2 * time.Hour), Text: "stale"})
+	br.deliver(a, toB, Event{Kind: Message, ID: "2", Time: then, Text: "first"})
+	br.deliver(a, toB, Event{Kind: Message, ID: "3", Time: then, Text: "second"})
+	br.deliver(a, toB, Event{Kind: Join, Time: then, From: "alice"})
+	if got := sent(); len(got) != 0 {
+		t.Fatalf("sent %+v while down", got)
+	}
+	if n := toB.q.Len(); n != 2 {
+		t.Errorf("queued %d events, want 2", n)
+	}
+
+	// The stale event expired, and the next was dropped from the full queue.
+	b.down = false
+	br.replay(a, toB)
+	if n := toB.q.Len(); n != 0 {
+		t.Errorf("queued %d events after replay, want 0", n)
+	}
+	// Messages are now sent directly, and edits refer to replayed messages.
+	br.deliver(a, toB, Event{Kind: Edit, Time: then, Target: Ref{ID: "3"}, Text: "2nd"})
+	got := sent()
+	want := []Event{
+		{Kind: Message, ID: "3", Time: then, Text: stamp + "second"},
+		{Kind: Notice, Time: then, From: "alice", Text: stamp + "alice joined"},
+		{Kind: Notice, Network: "relay", Text: "relay dropped 2 messages for b while it was unreachable"},
+		{Kind: Edit, Time: then, Target: Ref{ID: "b1"}, Text: "2nd"},
+	}
+	if len(got) == 4 {
+		// The summary is sent at the time of the replay.
+		got[2].Time = time.Time{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %+v, want %+v", got, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Kind: Message, Text: "hi"}, "hi"},
+		{Event{Kind: Message, Text: "hi", ReplyTo: &Ref{From: "bob", Text: "hello"}}, "[re bob: 'hello'] hi"},
+		{Event{Kind: Message, Text: "hi", ReplyTo: &Ref{ID: "1"}}, "[re thread] hi"},
+		{Event{Kind: Action, From: "alice", Text: "waves"}, "* alice waves"},
+		{Event{Kind: Join, From: "alice"}, "alice joined"},
+		{Event{Kind: Part, From: "alice"}, "alice parted"},
+		{Event{Kind: Part, From: "alice", Text: "bye"}, "alice parted: bye"},
+		{Event{Kind: Quit, From: "alice", Text: "ping timeout"}, "alice quit: ping timeout"},
+		{Event{Kind: Nick, From: "alice", Subject: "alicia"}, "alice is now alicia"},
+		{Event{Kind: Kick, From: "bob", Subject: "carol", Text: "spam"}, "bob kicked carol: spam"},
+		{Event{Kind: Topic, From: "alice", Text: "news"}, "alice changed the topic to: news"},
+		{Event{Kind: Edit, From: "alice", Text: "the"}, "* alice meant: the"},
+		{Event{Kind: Delete, From: "alice", Target: Ref{Text: "oops"}}, "* alice deleted 'oops'"},
+		{Event{Kind: Notice, Text: "could not set the IRC topic"}, "could not set the IRC topic"},
+	}
+	for _, test := range tests {
+		if got := Render(test.event); got != test.want {
+			t.Errorf("Render(%+v)=%q, want %q", test.event, got, test.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		who, text, want string
+	}{
+		{"bob", "hi", "bob: 'hi'"},
+		{"", "hi  there", "'hi there'"},
+		{"bob", "does anyone know how to fix the frobnicator?", "bob: 'does anyone know how to fix…'"},
+	}
+	for _, test := range tests {
+		if got := Quote(test.who, test.text); got != test.want {
+			t.Errorf("Quote(%q, %q)=%q, want %q", test.who, test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBufferLen is the default number of events buffered
+// in each direction of a bridge.
+const DefaultBufferLen = 100
+
+// An Overflow is what a bridge does with an event
+// from an endpoint whose buffer is full,
+// because the other endpoint is slower to send events
+// than the endpoint is to report them.
+type Overflow int
+
+const (
+	// Block waits for room in the buffer,
+	// which blocks the endpoint from reporting events.
+	Block Overflow = iota
+	// DropOldest drops the oldest event in the buffer.
+	DropOldest
+	// Coalesce merges a message into the newest message in the buffer
+	// if they can be coalesced, and otherwise drops the oldest event.
+	Coalesce
+)
+
+var overflowNames = []string{
+	Block:      "block",
+	DropOldest: "drop-oldest",
+	Coalesce:   "coalesce",
+}
+
+func (o Overflow) String() string {
+	if o < 0 || int(o) >= len(overflowNames) {
+		return "unknown"
+	}
+	return overflowNames[o]
+}
+
+// Stats are the counts of events from an endpoint
+// that were not relayed as they were reported,
+// because its buffer overflowed.
+type Stats struct {
+	// Dropped is the number of events dropped.
+	Dropped int
+	// Coalesced is the number of messages merged into an earlier message.
+	Coalesced int
+}
+
+// CoalesceMessages returns message b merged into message a,
+// and whether they can be merged:
+// whether both are messages with text and no attachments
+// from the same sender,
+// and b does not reply to a message.
+// The merged message has the ID of b,
+// and its Parts are the messages merged into it,
+// so that a correction of any of them can be applied with CorrectPart.
+func CoalesceMessages(a, b Event) (Event, bool) {
+	if !coalescable(a) || !coalescable(b) || b.ReplyTo != nil ||
+		a.Network != b.Network || a.From != b.From || a.UserID != b.UserID ||
+		a.Self != b.Self || a.Bot != b.Bot {
+		return a, false
+	}
+	a.Parts = append(parts(a), parts(b)...)
+	a.ID = b.ID
+	a.Text += "\n" + b.Text
+	return a, true
+}
+
+// CorrectPart returns message e with its part with text old
+// corrected to text, or removed if text is empty,
+// and whether e has such a part.
+// A message that is not coalesced is its only part.
+func CorrectPart(e Event, old, text string) (Event, bool) {
+	ps := parts(e)
+	for i := len(ps) - 1; i >= 0; i-- {
+		if ps[i].Text != old {
+			continue
+		}
+		if text == "" {
+			ps = append(ps[:i], ps[i+1:]...)
+		} else {
+			ps[i].Text = text
+		}
+		var lines []string
+		for _, p := range ps {
+			lines = append(lines, p.Text)
+		}
+		e.Text = strings.Join(lines, "\n")
+		e.Parts = ps
+		if len(ps) > 0 {
+			e.ID = ps[len(ps)-1].ID
+		}
+		return e, true
+	}
+	return e, false
+}
+
+// parts returns a copy of the parts of message e.
+func parts(e Event) []Ref {
+	if len(e.Parts) == 0 {
+		return []Ref{{ID: e.ID, From: e.From, Text: e.Text}}
+	}
+	return append([]Ref(nil), e.Parts...)
+}
+
+// coalescable returns whether an event is a message
+// that may be coalesced with others.
+func coalescable(e Event) bool {
+	return e.Kind == Message && e.Text != "" && len(e.Attachments) == 0
+}
+
+// maxCoalesced is the maximum number of messages coalesced into one.
+const maxCoalesced = 20
+
+// A coalescer holds a message for a time window,
+// coalescing the messages that follow it from the same sender.
+type coalescer struct {
+	// window is how long a message is held,
+	// or zero if messages are not coalesced.
+	window time.Duration
+	held   *Event
+	n      int
+	timer  *time.Timer
+}
+
+// add returns the events to send on receiving event e.
+func (c *coalescer) add(e Event) []Event {
+	if c.window <= 0 {
+		return []Event{e}
+	}
+	var send []Event
+	if c.held != nil {
+		if m, ok := CoalesceMessages(*c.held, e); ok && c.n < maxCoalesced {
+			*c.held = m
+			c.n++
+			return nil
+		}
+		send = c.flush()
+	}
+	if !coalescable(e) {
+		return append(send, e)
+	}
+	c.held, c.n = &e, 1
+	c.timer = time.NewTimer(c.window)
+	return send
+}
+
+// due returns a channel that receives when the held message is due to be sent,
+// or nil if no message is held.
+func (c *coalescer) due() <-chan time.Time {
+	if c.held == nil {
+		return nil
+	}
+	return c.timer.C
+}
+
+// flush returns the held message, if any, and stops holding it.
+func (c *coalescer) flush() []Event {
+	if c.held == nil {
+		return nil
+	}
+	c.timer.Stop()
+	e := *c.held
+	c.held = nil
+	return []Event{e}
+}
+
+// A buffer is a bounded queue of events
+// between an endpoint that reports them and the bridge that relays them.
+type buffer struct {
+	n        int
+	overflow Overflow
+	// ready receives a value when an event is put in the buffer.
+	ready chan struct{}
+	// space receives a value when an event is taken from the buffer.
+	space chan struct{}
+
+	sync.Mutex
+	events []Event
+	stats  Stats
+}
+
+func newBuffer(n int, overflow Overflow) *buffer {
+	return &buffer{
+		n:        n,
+		overflow: overflow,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}, 1),
+	}
+}
+
+// put adds an event to the buffer.
+// If the buffer is full, it handles the event by the buffer's overflow;
+// to Block, it waits until there is room or stop is closed.
+func (buf *buffer) put(e Event, stop <-chan struct{}) {
+	for {
+		buf.Lock()
+		if len(buf.events) < buf.n || buf.overflow != Block {
+			buf.add(e)
+			buf.Unlock()
+			signal(buf.ready)
+			return
+		}
+		buf.Unlock()
+		select {
+		case <-buf.space:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// add adds an event to the buffer, making room if it is full.
+// It is called with the buffer locked.
+func (buf *buffer) add(e Event) {
+	if len(buf.events) >= buf.n && buf.overflow == Coalesce {
+		last := len(buf.events) - 1
+		if c, ok := CoalesceMessages(buf.events[last], e); ok {
+			buf.events[last] = c
+			buf.stats.Coalesced++
+			return
+		}
+	}
+	for len(buf.events) >= buf.n {
+		buf.events[0] = Event{}
+		buf.events = buf.events[1:]
+		buf.stats.Dropped++
+	}
+	buf.events = append(buf.events, e)
+}
+
+// take removes and returns the oldest event in the buffer,
+// and whether there was one.
+func (buf *buffer) take() (Event, bool) {
+	buf.Lock()
+	defer buf.Unlock()
+	if len(buf.events) == 0 {
+		return Event{}, false
+	}
+	e := buf.events[0]
+	buf.events[0] = Event{}
+	buf.events = buf.events[1:]
+	signal(buf.space)
+	return e, true
+}
+
+// Stats returns the buffer's overflow counts.
+func (buf *buffer) Stats() Stats {
+	buf.Lock()
+	defer buf.Unlock()
+	return buf.stats
+}
+
+// signal sends to a channel with a buffer of one, unless it is full.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBufferOverflow(t *testing.T) {
+	msg := func(from, text string) Event { return Event{Kind: Message, From: from, Text: text} }
+	events := []Event{
+		msg("alice", "one"),
+		msg("bob", "two"),
+		msg("bob", "three"),
+		{Kind: Join, From: "carol"},
+		msg("carol", "four"),
+	}
+	tests := []struct {
+		overflow Overflow
+		want     []Event
+		stats    Stats
+	}{
+		{DropOldest, []Event{{Kind: Join, From: "carol"}, msg("carol", "four")}, Stats{Dropped: 3}},
+		{Coalesce, []Event{{Kind: Join, From: "carol"}, msg("carol", "four")}, Stats{Dropped: 2, Coalesced: 1}},
+	}
+	for _, test := range tests {
+		buf := newBuffer(2, test.overflow)
+		for _, e := range events {
+			buf.put(e, nil)
+		}
+		var got []Event
+		for {
+			e, ok := buf.take()
+			if !ok {
+				break
+			}
+			got = append(got, e)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: took %+v, want %+v", test.overflow, got, test.want)
+		}
+		if s := buf.Stats(); s != test.stats {
+			t.Errorf("%v: Stats()=%+v, want %+v", test.overflow, s, test.stats)
+		}
+	}
+}
+
+func TestBufferBlock(t *testing.T) {
+	buf := newBuffer(1, Block)
+	buf.put(Event{Text: "one"}, nil)
+	done := make(chan struct{})
+	go func() {
+		buf.put(Event{Text: "two"}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("put did not block on a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if e, _ := buf.take(); e.Text != "one" {
+		t.Errorf("take()=%+v, want one", e)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("put blocked after take")
+	}
+	if e, _ := buf.take(); e.Text != "two" {
+		t.Errorf("take()=%+v, want two", e)
+	}
+
+	// Closing stop abandons the put.
+	buf.put(Event{Text: "three"}, nil)
+	stop := make(chan struct{})
+	close(stop)
+	buf.put(Event{Text: "four"}, stop)
+	if s := buf.Stats(); s != (Stats{}) {
+		t.Errorf("Stats()=%+v, want none", s)
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+	msg := func(from, text string) Event { return Event{Kind: Message, ID: text, From: from, Text: text} }
+	c := &coalescer{window: time.Hour}
+	var sent []Event
+	for _, e := range []Event{
+		msg("alice", "one"),
+		msg("alice", "two"),
+		msg("bob", "three"),
+		{Kind: Join, From: "carol"},
+		msg("bob", "four"),
+	} {
+		sent = append(sent, c.add(e)...)
+	}
+	if c.due() == nil {
+		t.Fatalf("due()=nil while holding a message")
+	}
+	sent = append(sent, c.flush()...)
+	want := []Event{
+		{Kind: Message, ID: "two", From: "alice", Text: "one\ntwo", Parts: []Ref{
+			{ID: "one", From: "alice", Text: "one"},
+			{ID: "two", From: "alice", Text: "two"},
+		}},
+		msg("bob", "three"),
+		{Kind: Join, From: "carol"},
+		msg("bob", "four"),
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %+v, want %+v", sent, want)
+	}
+	if c.due() != nil {
+		t.Errorf("due()!=nil after flush")
+	}
+}
+
+func TestCoalesceMessages(t *testing.T) {
+	a := Event{Kind: Message, ID: "1", From: "alice", Text: "one"}
+	tests := []struct {
+		b    Event
+		want string
+		ok   bool
+	}{
+		{Event{Kind: Message, ID: "2", From: "alice", Text: "two"}, "one\ntwo", true},
+		{Event{Kind: Message, From: "bob", Text: "two"}, "one", false},
+		{Event{Kind: Action, From: "alice", Text: "waves"}, "one", false},
+		{Event{Kind: Message, From: "alice", Text: "two", ReplyTo: &Ref{ID: "0"}}, "one", false},
+		{Event{Kind: Message, From: "alice", Attachments: []Attachment{{Name: "a.png"}}}, "one", false},
+	}
+	for _, test := range tests {
+		got, ok := CoalesceMessages(a, test.b)
+		// Merged messages have the ID of the last message.
+		wantID := "1"
+		if test.ok {
+			wantID = "2"
+		}
+		if got.Text != test.want || ok != test.ok || got.ID != wantID {
+			t.Errorf("CoalesceMessages(%+v, %+v)=%+v, %v, want text %q, %v", a, test.b, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestCorrectPart(t *testing.T) {
+	a := Event{Kind: Message, ID: "1", From: "alice", Text: "one"}
+	b := Event{Kind: Message, ID: "2", From: "alice", Text: "two"}
+	e, _ := CoalesceMessages(a, b)
+	tests := []struct {
+		old, text string
+		want      string
+		wantID    string
+		ok        bool
+	}{
+		{"one", "uno", "uno\ntwo", "2", true},
+		{"two", "dos", "one\ndos", "2", true},
+		{"two", "", "one", "1", true},
+		{"one", "", "two", "2", true},
+		{"three", "tres", "one\ntwo", "2", false},
+	}
+	for _, test := range tests {
+		got, ok := CorrectPart(e, test.old, test.text)
+		if ok != test.ok || got.Text != test.want || got.ID != test.wantID {
+			t.Errorf("CorrectPart(%q, %q)=%+v, %v, want text %q, ID %q, %v",
+				test.old, test.text, got, ok, test.want, test.wantID, test.ok)
+		}
+	}
+}
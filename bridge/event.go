@@ -0,0 +1,232 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Kind is a kind of event.
+type Kind int
+
+const (
+	// Message is a message said by From.
+	Message Kind = iota
+	// Action is a /me action by From.
+	Action
+	// Notice is a notice about the channel.
+	// It is not attributed to From, but may mention it.
+	Notice
+	// Join is From joining the channel.
+	Join
+	// Part is From leaving the channel, for the reason Text.
+	Part
+	// Quit is From disconnecting from the network, for the reason Text.
+	Quit
+	// Nick is From changing their nick to Subject.
+	Nick
+	// Kick is From removing Subject from the channel, for the reason Text.
+	Kick
+	// Topic is From changing the channel topic to Text.
+	Topic
+	// Edit is From changing the message Target to Text.
+	Edit
+	// Delete is From deleting the message Target.
+	Delete
+	// Reaction is reactions to the message Target.
+	// Text describes them, for example, "alice reacted :+1: to bob: 'hi'".
+	Reaction
+)
+
+var kindNames = []string{
+	Message:  "message",
+	Action:   "action",
+	Notice:   "notice",
+	Join:     "join",
+	Part:     "part",
+	Quit:     "quit",
+	Nick:     "nick",
+	Kick:     "kick",
+	Topic:    "topic",
+	Edit:     "edit",
+	Delete:   "delete",
+	Reaction: "reaction",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return "unknown"
+	}
+	return kindNames[k]
+}
+
+// MarshalText returns the name of the kind,
+// so that stored events do not depend on the order of kinds.
+func (k Kind) MarshalText() ([]byte, error) {
+	if k < 0 || int(k) >= len(kindNames) {
+		return nil, fmt.Errorf("unknown kind %d", int(k))
+	}
+	return []byte(kindNames[k]), nil
+}
+
+// UnmarshalText sets the kind to the kind with the given name.
+func (k *Kind) UnmarshalText(text []byte) error {
+	for i, name := range kindNames {
+		if name == string(text) {
+			*k = Kind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown kind %q", text)
+}
+
+// An Event is something that happened in an endpoint's channel.
+type Event struct {
+	Kind Kind
+
+	// ID identifies a Message or Action on its endpoint,
+	// or is empty if it cannot be referred to later.
+	ID string
+
+	// Network is the name of the sender's network,
+	// for example, the host of an IRC server.
+	Network string
+
+	// Time is when the event happened on its network.
+	Time time.Time
+
+	// From is the display name of the sender,
+	// or of the user that a notice, such as a Join, is about.
+	From string
+	// User is the sender's username, if it differs from From.
+	User string
+	// UserID is the ID of the sender on their network, if known.
+	UserID string
+	// Bot is whether the sender is a bot.
+	Bot bool
+	// Self is whether the sender is the user
+	// whom the relay speaks for on other endpoints.
+	// Their messages are relayed without attribution.
+	Self bool
+
+	// Text is the text of the event.
+	// It may span several lines.
+	Text string
+
+	// Parts are the messages coalesced into a Message, oldest first,
+	// or nil if it is not coalesced; see CoalesceMessages.
+	Parts []Ref
+
+	// Subject is the new nick of a Nick, or the removed user of a Kick.
+	Subject string
+
+	// Target is the message that an Edit, Delete, or Reaction refers to.
+	Target Ref
+
+	// ReplyTo is the message that a Message or Action replies to,
+	// or nil if it is not a reply.
+	ReplyTo *Ref
+
+	// Attachments are the files shared with a Message.
+	Attachments []Attachment
+}
+
+// A Ref refers to a message.
+type Ref struct {
+	// ID is the ID of the message on the event's endpoint,
+	// or empty if it is unknown there.
+	ID string
+	// From and Text are the sender and text of the message, if known,
+	// for endpoints that cannot refer to it by ID.
+	From string
+	Text string
+}
+
+// An Attachment is a file shared with a message.
+type Attachment struct {
+	Name     string
+	MimeType string
+	// Size is the size of the file in bytes.
+	Size int64
+	URL  string
+}
+
+// Body returns the text of a Message or Action,
+// prefixed with a quote of the message it replies to, if any,
+// for example, "[re bob: 'does anyone know…'] turn it off and on".
+func (e Event) Body() string {
+	if e.ReplyTo == nil || e.Text == "" {
+		return e.Text
+	}
+	re := "thread"
+	if e.ReplyTo.Text != "" {
+		re = Quote(e.ReplyTo.From, e.ReplyTo.Text)
+	}
+	return "[re " + re + "] " + e.Text
+}
+
+// Render returns the plain text of an event,
+// for endpoints that have no richer way to render it.
+// Messages are not attributed,
+// and the Text of Notice and Reaction events is returned as is.
+func Render(e Event) string {
+	reason := func(text string) string {
+		if e.Text == "" {
+			return text
+		}
+		return text + ": " + e.Text
+	}
+	switch e.Kind {
+	case Message:
+		return e.Body()
+	case Action:
+		return "* " + e.From + " " + e.Body()
+	case Join:
+		return e.From + " joined"
+	case Part:
+		return reason(e.From + " parted")
+	case Quit:
+		return reason(e.From + " quit")
+	case Nick:
+		return e.From + " is now " + e.Subject
+	case Kick:
+		return reason(e.From + " kicked " + e.Subject)
+	case Topic:
+		return e.From + " changed the topic to: " + e.Text
+	case Edit:
+		return "* " + e.From + " meant: " + e.Text
+	case Delete:
+		return "* " + e.From + " deleted " + Quote("", e.Target.Text)
+	}
+	return e.Text
+}
+
+// quoteLen is the maximum number of runes in a quote of a message.
+const quoteLen = 30
+
+// Quote returns a short quote of a message from who:
+// its first few words, followed by … if it was shortened.
+// If who is empty, the quote is not attributed.
+func Quote(who, text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > quoteLen {
+		var n int
+		for i := range text {
+			if n == quoteLen {
+				text = text[:i]
+				break
+			}
+			n++
+		}
+		if j := strings.LastIndexFunc(text, unicode.IsSpace); j > 0 {
+			text = text[:j]
+		}
+		text += "…"
+	}
+	if who == "" {
+		return "'" + text + "'"
+	}
+	return who + ": '" + text + "'"
+}
@@ -0,0 +1,203 @@
+package bridge
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultQueueLen is the default maximum number of events
+	// queued for an endpoint.
+	DefaultQueueLen = 1000
+	// DefaultQueueAge is the default maximum age of a queued event.
+	DefaultQueueAge = time.Hour
+	// DefaultRetry is the default interval between attempts
+	// to send queued events.
+	DefaultRetry = 15 * time.Second
+)
+
+// An UnavailableError is returned by an endpoint's Send
+// if the endpoint cannot reach its network.
+// The event is queued and sent again later,
+// so an endpoint that sent part of it should not send that part again.
+type UnavailableError struct{ Err error }
+
+func (err UnavailableError) Error() string {
+	return "unavailable: " + err.Err.Error()
+}
+
+// A Queue is a first-in, first-out queue of events
+// awaiting delivery to an endpoint.
+type Queue interface {
+	// Push adds an event to the back of the queue.
+	Push(Event) error
+	// Peek returns the event at the front of the queue,
+	// and whether there is one.
+	Peek() (Event, bool, error)
+	// Pop removes the event at the front of the queue.
+	Pop() error
+	// Len returns the number of events in the queue.
+	Len() int
+}
+
+// A QueueStore stores named queues.
+type QueueStore interface {
+	// Queue returns the queue with the given name,
+	// creating it if it does not exist.
+	Queue(name string) (Queue, error)
+}
+
+// An outbox holds the events awaiting delivery to an endpoint.
+type outbox struct {
+	dst Endpoint
+	q   Queue
+	// dropped is the number of events dropped from q
+	// since the last summary.
+	dropped int
+}
+
+// outbox returns the outbox of dst.
+func (b *Bridge) outbox(dst Endpoint) (*outbox, error) {
+	side := "a"
+	if dst == b.B {
+		side = "b"
+	}
+	q, err := b.Queues.Queue(b.Name + "\x00" + side)
+	if err != nil {
+		return nil, fmt.Errorf("bridge %s failed to open queue: %v", b.Name, err)
+	}
+	return &outbox{dst: dst, q: q}, nil
+}
+
+// deliver sends an event from src to the outbox's endpoint,
+// after the events already queued for it.
+// If the endpoint is unavailable, the event is queued.
+// An event without a time is given the current time,
+// so that it is the same event when it is sent again.
+func (b *Bridge) deliver(src Endpoint, o *outbox, e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if o.q.Len() > 0 {
+		b.enqueue(o, e)
+		b.replay(src, o)
+		return
+	}
+	if err := b.send(src, o.dst, e); err != nil {
+		b.enqueue(o, e)
+	}
+}
+
+// enqueue adds an event to the outbox's queue,
+// dropping the oldest event if the queue is full.
+func (b *Bridge) enqueue(o *outbox, e Event) {
+	for o.q.Len() >= b.QueueLen {
+		if err := o.q.Pop(); err != nil {
+			log.Printf("bridge %s failed to drop queued event: %v", b.Name, err)
+			return
+		}
+		o.dropped++
+	}
+	if err := o.q.Push(e); err != nil {
+		log.Printf("bridge %s failed to queue event: %v", b.Name, err)
+		o.dropped++
+	}
+}
+
+// replay sends the events queued for the outbox's endpoint in order,
+// noting when they happened,
+// until the queue is empty or the endpoint is unavailable.
+// Events older than the bridge's QueueAge,
+// and events that cannot be read, are dropped.
+// Once the queue is empty, a notice reports how many events were dropped.
+func (b *Bridge) replay(src Endpoint, o *outbox) {
+	for {
+		e, ok, err := o.q.Peek()
+		if err != nil {
+			// Drop the unreadable event, rather than retry it forever.
+			log.Printf("bridge %s failed to read queue: %v", b.Name, err)
+			o.dropped++
+		} else if !ok {
+			break
+		} else if time.Since(e.Time) > b.QueueAge {
+			o.dropped++
+		} else if err := b.send(src, o.dst, replayed(e)); err != nil {
+			return
+		}
+		if err := o.q.Pop(); err != nil {
+			log.Printf("bridge %s failed to pop queue: %v", b.Name, err)
+			return
+		}
+	}
+	if o.dropped == 0 {
+		return
+	}
+	n := Event{
+		Kind:    Notice,
+		Network: "relay",
+		Time:    time.Now(),
+		Text:    fmt.Sprintf("relay dropped %d messages for %v while it was unreachable", o.dropped, o.dst),
+	}
+	if _, err := o.dst.Send(n); err == nil {
+		o.dropped = 0
+	}
+}
+
+// replayed returns a queued event as it is replayed,
+// with its text prefixed by when it happened.
+// Edits, deletions, and topics are replayed as is.
+func replayed(e Event) Event {
+	stamp := "[" + e.Time.UTC().Format("Jan 2 15:04 MST") + "] "
+	switch e.Kind {
+	case Message, Action, Notice, Reaction:
+		if e.Text != "" {
+			e.Text = stamp + e.Text
+		}
+		return e
+	case Join, Part, Quit, Nick, Kick:
+		return notice(e, stamp+Render(e))
+	}
+	return e
+}
+
+// memQueue is a Queue in memory.
+type memQueue struct {
+	events []Event
+}
+
+func (q *memQueue) Push(e Event) error {
+	q.events = append(q.events, e)
+	return nil
+}
+
+func (q *memQueue) Peek() (Event, bool, error) {
+	if len(q.events) == 0 {
+		return Event{}, false, nil
+	}
+	return q.events[0], true, nil
+}
+
+func (q *memQueue) Pop() error {
+	if len(q.events) > 0 {
+		q.events[0] = Event{}
+		q.events = q.events[1:]
+	}
+	return nil
+}
+
+func (q *memQueue) Len() int {
+	return len(q.events)
+}
+
+// memQueues is a QueueStore in memory.
+type memQueues map[string]*memQueue
+
+func (s memQueues) Queue(name string) (Queue, error) {
+	q, ok := s[name]
+	if !ok {
+		q = &memQueue{}
+		s[name] = q
+	}
+	return q, nil
+}
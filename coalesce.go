@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/velour/relay/bridge"
+)
+
+// maxBursts is the number of slack posts of coalesced messages remembered,
+// so that corrections of their messages can be applied.
+const maxBursts = 100
+
+// A burst is a slack post of IRC messages from one sender,
+// coalesced by the bridge or added to the post by updating it.
+type burst struct {
+	ts       string
+	threadTS string
+	// e is the post's messages, coalesced.
+	e bridge.Event
+	// start is when the post was made.
+	start time.Time
+}
+
+// bursts tracks the slack posts of coalesced IRC messages,
+// so that corrections of the messages can be applied to the posts,
+// and the last post, which IRC messages are added to
+// when a bridge coalesces messages by updating its last post.
+// It is safe for concurrent use.
+type bursts struct {
+	// window is how long after a post messages are added to it.
+	window time.Duration
+
+	sync.Mutex
+	// last is the last post, or nil if it may not be added to.
+	last *burst
+	// posts are the recent posts of coalesced messages, keyed by timestamp.
+	posts map[string]*burst
+	order []string
+}
+
+func newBursts(window time.Duration) *bursts {
+	return &bursts{window: window, posts: make(map[string]*burst)}
+}
+
+// posted records a post of event e.
+// Later messages may be added to it if e is a message.
+func (b *bursts) posted(ts, threadTS string, e bridge.Event) {
+	b.Lock()
+	defer b.Unlock()
+	b.last = nil
+	if e.Kind != bridge.Message {
+		return
+	}
+	b.last = &burst{ts: ts, threadTS: threadTS, e: e, start: time.Now()}
+	if len(e.Parts) > 0 {
+		b.remember(b.last)
+	}
+}
+
+// interrupt records that someone else posted in the channel,
+// so the last post may not be added to.
+func (b *bursts) interrupt() {
+	b.Lock()
+	defer b.Unlock()
+	b.last = nil
+}
+
+// extend returns the last post with message e added to it,
+// and whether it may be added:
+// whether e is from the post's sender,
+// posted to the same thread, within the window.
+// The post is not changed until it is updated.
+func (b *bursts) extend(e bridge.Event, threadTS string) (burst, bool) {
+	b.Lock()
+	defer b.Unlock()
+	if b.last == nil || b.last.threadTS != threadTS || time.Since(b.last.start) > b.window {
+		return burst{}, false
+	}
+	m, ok := bridge.CoalesceMessages(b.last.e, e)
+	if !ok {
+		return burst{}, false
+	}
+	p := *b.last
+	p.e = m
+	return p, true
+}
+
+// update records that a post was updated to p.
+func (b *bursts) update(p burst) {
+	b.Lock()
+	defer b.Unlock()
+	if b.last != nil && b.last.ts == p.ts {
+		b.last = &p
+	}
+	b.remember(&p)
+}
+
+// remember records post p of coalesced messages,
+// forgetting the oldest if there are more than maxBursts.
+// It is called with b locked.
+func (b *bursts) remember(p *burst) {
+	if _, ok := b.posts[p.ts]; !ok {
+		b.order = append(b.order, p.ts)
+	}
+	b.posts[p.ts] = p
+	for len(b.order) > maxBursts {
+		delete(b.posts, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// correct returns the last post or a post of coalesced messages
+// with timestamp ts with its message old corrected to text,
+// or removed if text is empty,
+// and whether there is such a post with such a message.
+// The post is not changed until it is updated.
+func (b *bursts) correct(ts, old, text string) (burst, bool) {
+	b.Lock()
+	defer b.Unlock()
+	p, ok := b.posts[ts]
+	if b.last != nil && b.last.ts == ts {
+		p, ok = b.last, true
+	}
+	if !ok {
+		return burst{}, false
+	}
+	e, ok := bridge.CorrectPart(p.e, old, text)
+	if !ok {
+		return burst{}, false
+	}
+	c := *p
+	c.e = e
+	return c, true
+}
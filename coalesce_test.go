@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/velour/relay/bridge"
+)
+
+func TestBursts(t *testing.T) {
+	msg := func(from, text string) bridge.Event {
+		return bridge.Event{Kind: bridge.Message, From: from, Text: text}
+	}
+	b := newBursts(time.Minute)
+	if _, ok := b.extend(msg("alice", "one"), ""); ok {
+		t.Fatalf("extended a post before any was posted")
+	}
+	b.posted("1.1", "", msg("alice", "one"))
+	if _, ok := b.extend(msg("bob", "hi"), ""); ok {
+		t.Errorf("extended alice's post with bob's message")
+	}
+	if _, ok := b.extend(msg("alice", "two"), "1.0"); ok {
+		t.Errorf("extended a post in the channel with a message for a thread")
+	}
+	p, ok := b.extend(msg("alice", "two"), "")
+	if !ok || p.ts != "1.1" || p.e.Text != "one\ntwo" {
+		t.Fatalf("extend()=%+v, %v, want 1.1 with one\\ntwo, true", p, ok)
+	}
+	b.update(p)
+
+	if p, ok := b.correct("1.1", "one", "won"); !ok || p.e.Text != "won\ntwo" {
+		t.Errorf("correct(one to won)=%+v, %v, want won\\ntwo, true", p, ok)
+	}
+	if p, ok := b.correct("1.1", "two", ""); !ok || p.e.Text != "one" {
+		t.Errorf("correct(delete two)=%+v, %v, want one, true", p, ok)
+	}
+	if _, ok := b.correct("1.2", "one", "won"); ok {
+		t.Errorf("corrected an unknown post")
+	}
+
+	// Someone else's post ends the burst, but its messages may still be corrected.
+	b.interrupt()
+	if _, ok := b.extend(msg("alice", "three"), ""); ok {
+		t.Errorf("extended a post after an interruption")
+	}
+	if _, ok := b.correct("1.1", "one", "won"); !ok {
+		t.Errorf("could not correct an extended post after an interruption")
+	}
+
+	// A post is not extended after the window.
+	b.posted("1.3", "", msg("alice", "four"))
+	b.last.start = time.Now().Add(-2 * time.Minute)
+	if _, ok := b.extend(msg("alice", "five"), ""); ok {
+		t.Errorf("extended a post after the window")
+	}
+
+	// A post of messages coalesced by the bridge may be corrected line-wise.
+	m, _ := bridge.CoalesceMessages(msg("bob", "six"), msg("bob", "seven"))
+	b.posted("1.4", "", m)
+	b.posted("1.5", "", msg("carol", "eight"))
+	if p, ok := b.correct("1.4", "six", "6"); !ok || p.e.Text != "6\nseven" {
+		t.Errorf("correct(six to 6)=%+v, %v, want 6\nseven, true", p, ok)
+	}
+	if _, ok := b.correct("1.5", "eight", "8"); !ok {
+		t.Errorf("could not correct the last post")
+	}
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+var configFile = flag.String("config", "", "A TOML file describing the IRC networks, slack workspaces, and bridges to run, instead of the other flags")
+
+// A config describes the IRC networks and slack workspaces to connect to
+// and the bridges between their channels.
+//
+// A config file is TOML, for example:
+//
+//	[irc.freenode]
+//	server = "irc.freenode.net:7000"
+//	nick = "relay"
+//
+//	[slack.work]
+//	token = "xoxb-…"
+//	nick = "alice"
+//
+//	[files]
+//	url = "https://relay.example.com/"
+//
+//	[state]
+//	path = "/var/lib/relay/state.db"
+//
+//	[[bridge]]
+//	irc = "freenode"
+//	irc_channel = "#go-nuts"
+//	slack = "work"
+//	slack_channel = "go"
+//	files = "host"
+//
+// Keys that are not given default to the value of the corresponding flag.
+type config struct {
+	// IRC and Slack are keyed by the names that bridges refer to.
+	IRC     map[string]*ircConfig
+	Slack   map[string]*slackConfig
+	Files   filesConfig
+	State   stateConfig
+	Bridges []*bridgeConfig
+}
+
+// An ircConfig describes an IRC network.
+type ircConfig struct {
+	Server   string `toml:"server"`
+	SSL      bool   `toml:"ssl"`
+	Password string `toml:"password"`
+	Nick     string `toml:"nick"`
+	FullName string `toml:"full_name"`
+
+	// Puppets is whether slack users are relayed by puppets:
+	// IRC connections of their own, with nicks ending in PuppetSuffix.
+	// Puppets disconnect after PuppetIdle,
+	// and at most PuppetLimit connect to the server's host.
+	Puppets      bool     `toml:"puppets"`
+	PuppetSuffix string   `toml:"puppet_suffix"`
+	PuppetIdle   duration `toml:"puppet_idle"`
+	PuppetLimit  int      `toml:"puppet_limit"`
+}
+
+// A slackConfig describes a slack workspace.
+type slackConfig struct {
+	API   string `toml:"api"`
+	Token string `toml:"token"`
+	// Nick is the username of the slack user
+	// whose messages are relayed to IRC.
+	Nick     string `toml:"nick"`
+	PageSize int    `toml:"page_size"`
+}
+
+// A filesConfig describes the file server
+// that re-hosts slack files for bridges with Files set to "host".
+type filesConfig struct {
+	Listen string   `toml:"listen"`
+	URL    string   `toml:"url"`
+	TTL    duration `toml:"ttl"`
+}
+
+// A stateConfig describes the file in which state is kept across restarts.
+type stateConfig struct {
+	// Path is the path of the file,
+	// or empty if state is kept only in memory.
+	Path string `toml:"path"`
+	// IDTTL is how long the IDs of relayed messages are kept.
+	IDTTL duration `toml:"id_ttl"`
+	// QueueLen is the maximum number of messages queued
+	// for each side of a bridge while it is unreachable.
+	QueueLen int `toml:"queue_len"`
+	// QueueAge is how long a queued message may wait to be relayed.
+	QueueAge duration `toml:"queue_age"`
+}
+
+// A bridgeConfig describes a bridge
+// between an IRC channel and a slack channel.
+type bridgeConfig struct {
+	// Name names the bridge in logs.
+	// If empty, the bridge is named after its channels.
+	Name string `toml:"name"`
+	// IRC and Slack are the names of the network and workspace.
+	IRC          string `toml:"irc"`
+	IRCChannel   string `toml:"irc_channel"`
+	Slack        string `toml:"slack"`
+	SlackChannel string `toml:"slack_channel"`
+
+	TopicSync  string `toml:"topic_sync"`
+	ASCIIEmoji bool   `toml:"ascii_emoji"`
+	Blocks     bool   `toml:"blocks"`
+	Bots       bool   `toml:"bots"`
+	Deletes    bool   `toml:"deletes"`
+	Files      string `toml:"files"`
+	Reactions  bool   `toml:"reactions"`
+	Threads    bool   `toml:"threads"`
+
+	// Shared is whether all slack channel members are relayed,
+	// rather than only the workspace's nick.
+	Shared bool `toml:"shared"`
+	// Allow, if not empty, are the only slack users and bots relayed,
+	// and Deny are those not relayed,
+	// given by username, display name, or ID.
+	Allow []string `toml:"allow"`
+	Deny  []string `toml:"deny"`
+	// Format is the text/template of attributed messages.
+	Format string `toml:"format"`
+
+	// Buffer is the number of messages from each side
+	// awaiting relay to the other,
+	// and Overflow is what to do with a message when they are full.
+	Buffer   int    `toml:"buffer"`
+	Overflow string `toml:"overflow"`
+
+	// Coalesce, if positive, is how long after an IRC message
+	// later messages from its sender are posted to slack with it.
+	Coalesce duration `toml:"coalesce"`
+	// UpdatePosts is whether the IRC message is posted at once,
+	// and its post updated with the later messages,
+	// rather than posted with them when Coalesce has passed.
+	UpdatePosts bool `toml:"coalesce_update"`
+}
+
+func (b *bridgeConfig) String() string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return b.IRC + "/" + b.IRCChannel + "<->" + b.Slack + "/" + b.SlackChannel
+}
+
+// A duration is a time.Duration written in TOML as a string, such as "24h".
+type duration struct{ time.Duration }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func flagIRCConfig() *ircConfig {
+	return &ircConfig{
+		Server:   *ircServer,
+		SSL:      *ircSSL,
+		Password: *ircPassword,
+		Nick:     *ircNick,
+		FullName: *ircFullName,
+
+		Puppets:      *ircPuppets,
+		PuppetSuffix: *puppetSuffix,
+		PuppetIdle:   duration{*puppetIdle},
+		PuppetLimit:  *puppetLimit,
+	}
+}
+
+func flagSlackConfig() *slackConfig {
+	return &slackConfig{
+		API:      *slackAPI,
+		Token:    *slackToken,
+		Nick:     *slackNick,
+		PageSize: *slackPage,
+	}
+}
+
+func flagFilesConfig() filesConfig {
+	return filesConfig{
+		Listen: *fileServe,
+		URL:    *fileURL,
+		TTL:    duration{*fileTTL},
+	}
+}
+
+func flagStateConfig() stateConfig {
+	return stateConfig{
+		Path:     *stateFile,
+		IDTTL:    duration{*idTTL},
+		QueueLen: *queueLen,
+		QueueAge: duration{*queueAge},
+	}
+}
+
+func flagBridgeConfig() *bridgeConfig {
+	return &bridgeConfig{
+		IRC:          "irc",
+		IRCChannel:   *ircChannel,
+		Slack:        "slack",
+		SlackChannel: *slackChannel,
+		TopicSync:    *topicMode,
+		ASCIIEmoji:   *asciiEmoji,
+		Blocks:       *slackBlocks,
+		Bots:         *slackBots,
+		Deletes:      *slackDeletes,
+		Files:        *slackFiles,
+		Reactions:    *slackReacts,
+		Threads:      *slackThreads,
+		Shared:       *slackShared,
+		Allow:        splitList(*slackAllow),
+		Deny:         splitList(*slackDeny),
+		Format:       *slackFormat,
+		Buffer:       *bufferLen,
+		Overflow:     *overflow,
+		Coalesce:     duration{*slackBurst},
+		UpdatePosts:  *slackUpdate,
+	}
+}
+
+// splitList returns the elements of a comma-separated list.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// flagKeys maps the keys of the config built from flags,
+// prefixed by their table, to the flags that set them.
+var flagKeys = map[string]string{
+	"irc.server":           "ircserver",
+	"irc.nick":             "ircnick",
+	"irc.puppet_suffix":    "ircpuppetsuffix",
+	"irc.puppet_idle":      "ircpuppetidle",
+	"irc.puppet_limit":     "ircpuppetlimit",
+	"slack.token":          "slacktoken",
+	"slack.nick":           "slacknick",
+	"slack.page_size":      "slackpagesize",
+	"files.url":            "fileurl",
+	"files.ttl":            "filettl",
+	"state.id_ttl":         "idttl",
+	"state.queue_len":      "queuelen",
+	"state.queue_age":      "queueage",
+	"bridge.irc_channel":   "ircchannel",
+	"bridge.slack_channel": "slackchannel",
+	"bridge.topic_sync":    "topicsync",
+	"bridge.buffer":        "buffer",
+	"bridge.overflow":      "overflow",
+	"bridge.coalesce":      "slackcoalesce",
+	"bridge.files":         "slackfiles",
+	"bridge.format":        "slackformat",
+}
+
+// loadConfig returns the config from the -config file,
+// or the single bridge config described by the other flags.
+func loadConfig() (*config, error) {
+	if *configFile == "" {
+		cfg := &config{
+			IRC:     map[string]*ircConfig{"irc": flagIRCConfig()},
+			Slack:   map[string]*slackConfig{"slack": flagSlackConfig()},
+			Files:   flagFilesConfig(),
+			State:   flagStateConfig(),
+			Bridges: []*bridgeConfig{flagBridgeConfig()},
+		}
+		if err := cfg.check(flagLocator{}); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			set = append(set, "-"+f.Name)
+		}
+	})
+	if len(set) > 0 {
+		return nil, fmt.Errorf("%s cannot be used with -config", strings.Join(set, ", "))
+	}
+	src, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(*configFile, string(src))
+}
+
+// parseConfig parses and checks the config in the TOML source.
+// Keys that are not given default to the value of their flag.
+func parseConfig(name, src string) (*config, error) {
+	var raw struct {
+		IRC     map[string]toml.Primitive `toml:"irc"`
+		Slack   map[string]toml.Primitive `toml:"slack"`
+		Files   toml.Primitive            `toml:"files"`
+		State   toml.Primitive            `toml:"state"`
+		Bridges []toml.Primitive          `toml:"bridge"`
+	}
+	md, err := toml.Decode(src, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	cfg := &config{
+		IRC:   make(map[string]*ircConfig),
+		Slack: make(map[string]*slackConfig),
+		Files: flagFilesConfig(),
+		State: flagStateConfig(),
+	}
+	for n, p := range raw.IRC {
+		cfg.IRC[n] = flagIRCConfig()
+		if err := md.PrimitiveDecode(p, cfg.IRC[n]); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	for n, p := range raw.Slack {
+		cfg.Slack[n] = flagSlackConfig()
+		if err := md.PrimitiveDecode(p, cfg.Slack[n]); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if md.IsDefined("files") {
+		if err := md.PrimitiveDecode(raw.Files, &cfg.Files); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if md.IsDefined("state") {
+		if err := md.PrimitiveDecode(raw.State, &cfg.State); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, p := range raw.Bridges {
+		b := flagBridgeConfig()
+		b.IRC, b.Slack = "", ""
+		if err := md.PrimitiveDecode(p, b); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		cfg.Bridges = append(cfg.Bridges, b)
+	}
+
+	loc := fileLocator{name: name, src: src}
+	var errs configErrors
+	unknown := make(map[string]bool)
+	undecoded := md.Undecoded()
+	// The keys of bridges are undecoded without their index,
+	// so find the bridges that have them.
+	bridges := make([]map[string]interface{}, len(raw.Bridges))
+	for i, p := range raw.Bridges {
+		md.PrimitiveDecode(p, &bridges[i])
+	}
+	for _, key := range undecoded {
+		// Only report the outermost of an unknown table's keys.
+		if unknown[key[:len(key)-1].String()] {
+			unknown[key.String()] = true
+			continue
+		}
+		unknown[key.String()] = true
+		table, k := []string(key[:len(key)-1]), key[len(key)-1]
+		if len(table) == 1 && table[0] == "bridge" {
+			for i, b := range bridges {
+				if _, ok := b[k]; ok {
+					where := loc.locate([]string{"bridge", strconv.Itoa(i)}, k)
+					errs = append(errs, &configError{Where: where, Msg: "unknown key"})
+				}
+			}
+			continue
+		}
+		errs = append(errs, &configError{Where: loc.locate(table, k), Msg: "unknown key"})
+	}
+	if err := cfg.check(loc); err != nil {
+		errs = append(errs, err.(configErrors)...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+// A configError is an error in the config.
+type configError struct {
+	// Where is the location of the error, such as
+	// "relay.toml:12: bridge[1].irc_channel" or "-ircchannel".
+	Where string
+	Msg   string
+}
+
+func (e *configError) Error() string { return e.Where + ": " + e.Msg }
+
+// configErrors are all of the errors in a config.
+type configErrors []*configError
+
+func (es configErrors) Error() string {
+	var s []string
+	for _, e := range es {
+		s = append(s, e.Error())
+	}
+	return strings.Join(s, "\n")
+}
+
+// check returns the errors in the config, if any,
+// located with loc.
+func (cfg *config) check(loc locator) error {
+	var errs configErrors
+	errorf := func(table []string, key string, format string, args ...interface{}) {
+		errs = append(errs, &configError{
+			Where: loc.locate(table, key),
+			Msg:   fmt.Sprintf(format, args...),
+		})
+	}
+	var names []string
+	for name := range cfg.IRC {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, table := cfg.IRC[name], []string{"irc", name}
+		if c.Server == "" {
+			errorf(table, "server", "missing server")
+		}
+		if c.Nick == "" {
+			errorf(table, "nick", "missing nick")
+		}
+		if !c.Puppets {
+			continue
+		}
+		if strings.ContainsAny(c.PuppetSuffix, " ,*?!@.:#&") {
+			errorf(table, "puppet_suffix", "puppet suffix %q has characters not allowed in nicks", c.PuppetSuffix)
+		}
+		if c.PuppetIdle.Duration <= 0 {
+			errorf(table, "puppet_idle", "puppet idle %s is not positive", c.PuppetIdle.Duration)
+		}
+		if c.PuppetLimit < 1 {
+			errorf(table, "puppet_limit", "puppet limit %d is less than 1", c.PuppetLimit)
+		}
+	}
+	names = names[:0]
+	for name := range cfg.Slack {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c, table := cfg.Slack[name], []string{"slack", name}
+		if c.Token == "" {
+			errorf(table, "token", "missing token")
+		}
+		if c.Nick == "" && !allShared(cfg.Bridges, name) {
+			errorf(table, "nick", "missing nick, required by bridges that are not shared")
+		}
+		if c.PageSize < 1 || c.PageSize > 1000 {
+			errorf(table, "page_size", "page size %d is not between 1 and 1000", c.PageSize)
+		}
+	}
+	if len(cfg.Bridges) == 0 {
+		errorf(nil, "", "no bridges")
+	}
+	var hosted bool
+	seen, named := make(map[string]int), make(map[string]int)
+	for i, b := range cfg.Bridges {
+		table := []string{"bridge", strconv.Itoa(i)}
+		switch _, ok := cfg.IRC[b.IRC]; {
+		case b.IRC == "":
+			errorf(table, "irc", "missing irc")
+		case !ok:
+			errorf(table, "irc", "no IRC network %q", b.IRC)
+		}
+		switch {
+		case b.IRCChannel == "":
+			errorf(table, "irc_channel", "missing irc_channel")
+		case !strings.HasPrefix(b.IRCChannel, "#") && !strings.HasPrefix(b.IRCChannel, "&"):
+			errorf(table, "irc_channel", "IRC channel %q does not begin with # or &", b.IRCChannel)
+		}
+		switch _, ok := cfg.Slack[b.Slack]; {
+		case b.Slack == "":
+			errorf(table, "slack", "missing slack")
+		case !ok:
+			errorf(table, "slack", "no slack workspace %q", b.Slack)
+		}
+		if b.SlackChannel == "" {
+			errorf(table, "slack_channel", "missing slack_channel")
+		}
+		if !validTopicMode(b.TopicSync) {
+			errorf(table, "topic_sync", "bad topic_sync %q: want none, both, irc-to-slack, or slack-to-irc", b.TopicSync)
+		}
+		switch b.Files {
+		case "permalink", "none":
+		case "host":
+			hosted = true
+		default:
+			errorf(table, "files", "bad files %q: want permalink, host, or none", b.Files)
+		}
+		if _, err := template.New("format").Parse(b.Format); err != nil {
+			errorf(table, "format", "bad format: %v", err)
+		}
+		if b.Buffer < 1 {
+			errorf(table, "buffer", "buffer %d is less than 1", b.Buffer)
+		}
+		if _, ok := overflows[b.Overflow]; !ok {
+			errorf(table, "overflow", "bad overflow %q: want block, drop-oldest, or coalesce", b.Overflow)
+		}
+		if b.Coalesce.Duration < 0 {
+			errorf(table, "coalesce", "coalesce %s is negative", b.Coalesce.Duration)
+		}
+		pair := b.IRC + "/" + b.IRCChannel + " " + b.Slack + "/" + strings.TrimPrefix(b.SlackChannel, "#")
+		if j, ok := seen[pair]; ok {
+			errorf(table, "", "duplicate of bridge[%d]", j)
+		}
+		seen[pair] = i
+		if b.Name == "" {
+			continue
+		}
+		// The IDs of a bridge's messages are stored by its name.
+		if j, ok := named[b.Name]; ok {
+			errorf(table, "name", "name %q is also bridge[%d]'s", b.Name, j)
+		}
+		named[b.Name] = i
+	}
+	if hosted {
+		if cfg.Files.URL == "" {
+			errorf([]string{"files"}, "url", "missing url, required by bridges with files = \"host\"")
+		}
+		if cfg.Files.TTL.Duration <= 0 {
+			errorf([]string{"files"}, "ttl", "ttl %s is not positive", cfg.Files.TTL.Duration)
+		}
+	}
+	if cfg.State.Path != "" && cfg.State.IDTTL.Duration <= 0 {
+		errorf([]string{"state"}, "id_ttl", "id_ttl %s is not positive", cfg.State.IDTTL.Duration)
+	}
+	if cfg.State.QueueLen < 1 {
+		errorf([]string{"state"}, "queue_len", "queue_len %d is less than 1", cfg.State.QueueLen)
+	}
+	if cfg.State.QueueAge.Duration <= 0 {
+		errorf([]string{"state"}, "queue_age", "queue_age %s is not positive", cfg.State.QueueAge.Duration)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// allShared returns whether all bridges on the slack workspace are shared.
+func allShared(bridges []*bridgeConfig, slack string) bool {
+	for _, b := range bridges {
+		if b.Slack == slack && !b.Shared {
+			return false
+		}
+	}
+	return true
+}
+
+// A locator describes where a config key was given.
+type locator interface {
+	// locate returns the location of the key in the table
+	// with the given path, such as ["irc", "freenode"],
+	// or ["bridge", "2"] for the third bridge.
+	// If key is empty, it returns the location of the table.
+	locate(table []string, key string) string
+}
+
+// A flagLocator locates keys of the config built from flags.
+type flagLocator struct{}
+
+func (flagLocator) locate(table []string, key string) string {
+	if len(table) == 0 {
+		return "flags"
+	}
+	if f, ok := flagKeys[table[0]+"."+key]; ok {
+		return "-" + f
+	}
+	return "flags"
+}
+
+// A fileLocator locates keys in a TOML config file.
+type fileLocator struct {
+	name string
+	src  string
+}
+
+func (l fileLocator) locate(table []string, key string) string {
+	var path string
+	switch {
+	case len(table) == 2 && table[0] == "bridge":
+		path = "bridge[" + table[1] + "]"
+	default:
+		path = strings.Join(table, ".")
+	}
+	if key != "" {
+		if path != "" {
+			path += "."
+		}
+		path += key
+	}
+	where := l.name
+	if n := l.line(table, key); n > 0 {
+		where += ":" + strconv.Itoa(n)
+	}
+	if path == "" {
+		return where
+	}
+	return where + ": " + path
+}
+
+// line returns the line number of the key in the table,
+// the line number of the table header if the key is not found,
+// or 0 if neither is found.
+// Array tables are given as ["bridge", index];
+// if the index is not given, the key is found in any element.
+func (l fileLocator) line(table []string, key string) int {
+	header, index := strings.Join(table, "."), -1
+	if len(table) > 0 && table[0] == "bridge" {
+		header = "bridge"
+		if len(table) == 2 {
+			index, _ = strconv.Atoi(table[1])
+		}
+	}
+	sub := strings.Join(append(table[:len(table):len(table)], key), ".")
+	in := header == ""
+	n, headerLine := -1, 0
+	for i, line := range strings.Split(l.src, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			name := tableName(line)
+			in = name == header
+			if in && strings.HasPrefix(line, "[[") {
+				n++
+				in = index < 0 || n == index
+			}
+			switch {
+			case in && headerLine == 0:
+				headerLine = i + 1
+			case key != "" && (name == sub || strings.HasPrefix(name, sub+".")):
+				return i + 1
+			}
+			continue
+		}
+		if in && key != "" && strings.HasPrefix(line, key) &&
+			strings.HasPrefix(strings.TrimSpace(line[len(key):]), "=") {
+			return i + 1
+		}
+	}
+	return headerLine
+}
+
+// tableName returns the name of the table
+// with the given header line, such as "irc.freenode".
+func tableName(header string) string {
+	header = strings.TrimLeft(header, "[")
+	if i := strings.Index(header, "]"); i >= 0 {
+		header = header[:i]
+	}
+	parts := strings.Split(header, ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testConfig = `[irc.freenode]
+server = "irc.freenode.net:7000"
+nick = "relay"
+
+[slack.work]
+token = "xoxb-token"
+nick = "alice"
+
+[[bridge]]
+irc = "freenode"
+irc_channel = "#go-nuts"
+slack = "work"
+slack_channel = "go"
+`
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig("relay.toml", testConfig)
+	if err != nil {
+		t.Fatalf("parseConfig failed: %v", err)
+	}
+	if len(cfg.Bridges) != 1 {
+		t.Fatalf("len(Bridges)=%d, want 1", len(cfg.Bridges))
+	}
+	b := cfg.Bridges[0]
+	if b.IRCChannel != "#go-nuts" || b.SlackChannel != "go" {
+		t.Errorf("bridge channels=%q, %q, want #go-nuts, go", b.IRCChannel, b.SlackChannel)
+	}
+	// Keys not given default to their flag.
+	if !b.Blocks || b.Files != "permalink" || b.TopicSync != topicNone {
+		t.Errorf("bridge=%+v, want flag defaults", b)
+	}
+	if c := cfg.Slack["work"]; c.API != *slackAPI || c.PageSize != *slackPage {
+		t.Errorf("slack.work=%+v, want flag defaults", c)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			src: testConfig + `
+[[bridge]]
+irc = "efnet"
+irc_channel = "go"
+slack = "work"
+slack_channel = "go"
+topic_sync = "sideways"
+irc_chanel = "#go"
+`,
+			want: []string{
+				`relay.toml:21: bridge[1].irc_chanel: unknown key`,
+				`relay.toml:16: bridge[1].irc: no IRC network "efnet"`,
+				`relay.toml:17: bridge[1].irc_channel: IRC channel "go" does not begin with # or &`,
+				`relay.toml:20: bridge[1].topic_sync: bad topic_sync "sideways": want none, both, irc-to-slack, or slack-to-irc`,
+			},
+		},
+		{
+			src: testConfig + `files = "host"
+
+[[bridge]]
+irc = "freenode"
+irc_channel = "#go-nuts"
+slack = "work"
+slack_channel = "#go"
+`,
+			want: []string{
+				`relay.toml:16: bridge[1]: duplicate of bridge[0]`,
+				`relay.toml: files.url: missing url, required by bridges with files = "host"`,
+			},
+		},
+		{
+			src: testConfig + `name = "go"
+
+[[bridge]]
+name = "go"
+irc = "freenode"
+irc_channel = "#go"
+slack = "work"
+slack_channel = "golang"
+overflow = "fifo"
+
+[state]
+path = "state.db"
+id_ttl = "0s"
+queue_len = 0
+`,
+			want: []string{
+				`relay.toml:22: bridge[1].overflow: bad overflow "fifo": want block, drop-oldest, or coalesce`,
+				`relay.toml:17: bridge[1].name: name "go" is also bridge[0]'s`,
+				`relay.toml:26: state.id_ttl: id_ttl 0s is not positive`,
+				`relay.toml:27: state.queue_len: queue_len 0 is less than 1`,
+			},
+		},
+		{
+			src: `[slack.work]
+nick = "alice"
+page_size = 0
+`,
+			want: []string{
+				`relay.toml:1: slack.work.token: missing token`,
+				`relay.toml:3: slack.work.page_size: page size 0 is not between 1 and 1000`,
+				`relay.toml: no bridges`,
+			},
+		},
+	}
+	for _, test := range tests {
+		_, err := parseConfig("relay.toml", test.src)
+		if err == nil {
+			t.Errorf("parseConfig(%q) succeeded, want errors", test.src)
+			continue
+		}
+		if got, want := err.Error(), strings.Join(test.want, "\n"); got != want {
+			t.Errorf("parseConfig(%q) errors:\n%s\nwant:\n%s", test.src, got, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// substRE matches an IRC correction of the form s/old/new/ or s/old/new/g.
+var substRE = regexp.MustCompile(`^s/((?:[^/\\]|\\.)+)/((?:[^/\\]|\\.)*)(?:/(g?))?$`)
+
+// A subst is a correction to a previous message.
+type subst struct {
+	old, new string
+	global   bool
+}
+
+// parseSubst parses an IRC correction of the form s/old/new/.
+// A slash in old or new may be escaped with a backslash.
+func parseSubst(text string) (subst, bool) {
+	m := substRE.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return subst{}, false
+	}
+	unescape := strings.NewReplacer(`\/`, `/`, `\\`, `\`).Replace
+	return subst{old: unescape(m[1]), new: unescape(m[2]), global: m[3] == "g"}, true
+}
+
+// apply returns the text with the correction applied,
+// and whether the text contained the text to replace.
+func (s subst) apply(text string) (string, bool) {
+	if !strings.Contains(text, s.old) {
+		return text, false
+	}
+	n := 1
+	if s.global {
+		n = -1
+	}
+	return strings.Replace(text, s.old, s.new, n), true
+}
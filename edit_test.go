@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSubst(t *testing.T) {
+	tests := []struct {
+		subst, text string
+		want        string
+		ok          bool
+	}{
+		{"s/teh/the/", "teh cat and teh dog", "the cat and teh dog", true},
+		{"s/teh/the/g", "teh cat and teh dog", "the cat and the dog", true},
+		{"s/teh/the", "teh cat", "the cat", true},
+		{`s/a\/b/c/`, "see a/b", "see c", true},
+		{"s/cat//", "cat", "", true},
+		{"s/fish/dog/", "teh cat", "teh cat", false},
+	}
+	for _, test := range tests {
+		s, ok := parseSubst(test.subst)
+		if !ok {
+			t.Errorf("parseSubst(%q) failed", test.subst)
+			continue
+		}
+		got, ok := s.apply(test.text)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%q.apply(%q)=%q,%v, want %q,%v", test.subst, test.text, got, ok, test.want, test.ok)
+		}
+	}
+
+	for _, text := range []string{"hello", "s//x/", "s/a/b/c/", "s/a/b/i", "this s/a/b/"} {
+		if s, ok := parseSubst(text); ok {
+			t.Errorf("parseSubst(%q)=%+v, want failure", text, s)
+		}
+	}
+}
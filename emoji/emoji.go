@@ -0,0 +1,124 @@
+// Package emoji translates between emoji shortcodes, like :tada:,
+// Unicode emoji, and ASCII smileys.
+package emoji
+
+import "strings"
+
+// Unicode returns the Unicode emoji for a shortcode name,
+// given without colons.
+func Unicode(name string) (string, bool) {
+	e, ok := unicodes[name]
+	return e, ok
+}
+
+// Shortcode returns the preferred shortcode name, without colons,
+// for a Unicode emoji.
+func Shortcode(emoji string) (string, bool) {
+	name, ok := shortcodes[emoji]
+	return name, ok
+}
+
+// Emojize returns text with each :shortcode: replaced by its Unicode emoji.
+// Unknown shortcodes, such as a workspace's custom emoji, are left as they are.
+func Emojize(text string) string {
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(text, ':')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(text[i+1:], ':')
+		if j < 0 {
+			break
+		}
+		name := text[i+1 : i+1+j]
+		e, ok := unicodes[name]
+		if !ok || !isName(name) {
+			// The closing colon may open the next shortcode.
+			out.WriteString(text[:i+1+j])
+			text = text[i+1+j:]
+			continue
+		}
+		out.WriteString(text[:i])
+		out.WriteString(e)
+		text = text[i+j+2:]
+	}
+	out.WriteString(text)
+	return out.String()
+}
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9',
+			r == '_', r == '-', r == '+', r == '\'':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// asciis maps ASCII smileys to Unicode emoji.
+var asciis = map[string]string{
+	":)":   "\U0001f642",
+	":-)":  "\U0001f642",
+	"(:":   "\U0001f642",
+	":(":   "\U0001f641",
+	":-(":  "\U0001f641",
+	":D":   "\U0001f604",
+	":-D":  "\U0001f604",
+	";)":   "\U0001f609",
+	";-)":  "\U0001f609",
+	":P":   "\U0001f61b",
+	":-P":  "\U0001f61b",
+	":p":   "\U0001f61b",
+	":-p":  "\U0001f61b",
+	":O":   "\U0001f62e",
+	":-O":  "\U0001f62e",
+	":o":   "\U0001f62e",
+	":-o":  "\U0001f62e",
+	":'(":  "\U0001f622",
+	":/":   "\U0001f615",
+	":-/":  "\U0001f615",
+	":|":   "\U0001f610",
+	":-|":  "\U0001f610",
+	"<3":   "\u2764\ufe0f",
+	"</3":  "\U0001f494",
+	"B)":   "\U0001f60e",
+	"B-)":  "\U0001f60e",
+	"XD":   "\U0001f606",
+	">:(":  "\U0001f620",
+	":*":   "\U0001f618",
+	":-*":  "\U0001f618",
+	"o/":   "\U0001f44b",
+	"\\o/": "\U0001f64c",
+}
+
+// FromASCII returns text with ASCII smileys, such as :) and <3,
+// replaced by Unicode emoji.
+// Only smileys separated from other text by whitespace are replaced,
+// so URLs and the like are left as they are.
+func FromASCII(text string) string {
+	var out strings.Builder
+	for len(text) > 0 {
+		i := strings.IndexAny(text, " \t")
+		if i < 0 {
+			i = len(text)
+		}
+		word := text[:i]
+		if e, ok := asciis[word]; ok {
+			word = e
+		}
+		out.WriteString(word)
+		if i < len(text) {
+			out.WriteByte(text[i])
+			i++
+		}
+		text = text[i:]
+	}
+	return out.String()
+}
@@ -0,0 +1,70 @@
+package emoji
+
+import "testing"
+
+func TestEmojize(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"no emoji", "no emoji"},
+		{":tada:", "🎉"},
+		{"nice :+1: work", "nice 👍 work"},
+		{":+1::skin-tone-2:", "👍🏻"},
+		{":smile::smile:", "😄😄"},
+		{"custom :partyparrot: emoji", "custom :partyparrot: emoji"},
+		{"time 10:30:tada:", "time 10:30🎉"},
+		{"http://example.com:8080/:x", "http://example.com:8080/:x"},
+		{":Tada:", ":Tada:"},
+		{"::", "::"},
+		{"unclosed :tada", "unclosed :tada"},
+	}
+	for _, test := range tests {
+		if got := Emojize(test.text); got != test.want {
+			t.Errorf("Emojize(%q)=%q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestShortcode(t *testing.T) {
+	for _, name := range []string{"+1", "tada", "smile"} {
+		e, ok := Unicode(name)
+		if !ok {
+			t.Errorf("Unicode(%q) failed", name)
+			continue
+		}
+		if got, ok := Shortcode(e); !ok || got != name {
+			t.Errorf("Shortcode(%q)=%q,%v, want %q,true", e, got, ok, name)
+		}
+	}
+}
+
+func TestTableNames(t *testing.T) {
+	for name := range unicodes {
+		if !isName(name) {
+			t.Errorf("unicodes has invalid name %q", name)
+		}
+	}
+	for e, name := range shortcodes {
+		if !isName(name) {
+			t.Errorf("shortcodes[%q] is invalid name %q", e, name)
+		}
+	}
+}
+
+func TestFromASCII(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"hi :)", "hi 🙂"},
+		{":( sad", "🙁 sad"},
+		{"i <3 go", "i ❤️ go"},
+		{"see http://x.com/:)", "see http://x.com/:)"},
+		{"a  :D\tb", "a  😄\tb"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := FromASCII(test.text); got != test.want {
+			t.Errorf("FromASCII(%q)=%q, want %q", test.text, got, test.want)
+		}
+	}
+}
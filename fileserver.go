@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFileSize is the size in bytes of the largest file re-hosted.
+	maxFileSize = 100 << 20
+	// maxTotalSize is the size in bytes of all re-hosted files
+	// above which the oldest are removed.
+	maxTotalSize = 1 << 30
+	// filePruneInterval is how often expired files are removed.
+	filePruneInterval = time.Minute
+)
+
+// A fileServer serves files re-hosted from slack
+// at unguessable URLs that expire.
+type fileServer struct {
+	// base is the public URL at which the server is reachable.
+	base string
+	ttl  time.Duration
+	dir  string
+	// maxSize is the total size of files kept; see maxTotalSize.
+	maxSize int64
+	stop    chan struct{}
+	once    sync.Once
+
+	sync.Mutex
+	files map[string]*hostedFile
+	// size is the total size of the stored files.
+	size int64
+}
+
+type hostedFile struct {
+	name     string
+	mimetype string
+	expires  time.Time
+	// fallback is the URL to redirect to if the file could not be stored.
+	fallback string
+	// ready is closed once the file is stored or has failed to be.
+	ready chan struct{}
+	// path, size, and err are set with the server locked.
+	// path is empty if the file is not stored.
+	path string
+	size int64
+	err  error
+}
+
+// errEvicted is the error of a file removed to make room for newer files.
+var errEvicted = errors.New("evicted to make room for newer files")
+
+// newFileServer returns a new fileServer that stores files in a temporary directory.
+// Expired files are removed every filePruneInterval until the server is closed.
+func newFileServer(base string, ttl time.Duration) (*fileServer, error) {
+	dir, err := ioutil.TempDir("", "relay-files")
+	if err != nil {
+		return nil, err
+	}
+	s := &fileServer{
+		base:    strings.TrimSuffix(base, "/"),
+		ttl:     ttl,
+		dir:     dir,
+		maxSize: maxTotalSize,
+		stop:    make(chan struct{}),
+		files:   make(map[string]*hostedFile),
+	}
+	go func() {
+		tick := time.NewTicker(filePruneInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				s.prune()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// close stops pruning and removes the server's directory.
+// It may be called more than once.
+func (s *fileServer) close() {
+	s.once.Do(func() {
+		close(s.stop)
+		if err := os.RemoveAll(s.dir); err != nil {
+			log.Println("file server failed to remove files:", err)
+		}
+	})
+}
+
+// add returns the URL of a file with the given name and MIME type,
+// whose contents are written by the write function in the background.
+// Until they are written, requests for the file wait;
+// if they cannot be written, or the file is evicted
+// to keep the total size of files below maxTotalSize,
+// requests are redirected to fallback.
+func (s *fileServer) add(name, mimetype, fallback string, write func(io.Writer) error) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:])
+	name = path.Base("/" + name)
+	f := &hostedFile{
+		name:     name,
+		mimetype: mimetype,
+		expires:  time.Now().Add(s.ttl),
+		fallback: fallback,
+		ready:    make(chan struct{}),
+	}
+	s.Lock()
+	s.files[token] = f
+	s.Unlock()
+	go func() {
+		stored, size, err := s.store(write)
+		if err != nil {
+			log.Println("failed to re-host slack file:", err)
+		}
+		s.Lock()
+		f.path, f.size, f.err = stored, size, err
+		s.size += size
+		s.evict()
+		s.Unlock()
+		close(f.ready)
+	}()
+	return s.base + "/" + token + "/" + url.PathEscape(name), nil
+}
+
+// store writes a file to the server's directory
+// and returns its path and size.
+func (s *fileServer) store(write func(io.Writer) error) (string, int64, error) {
+	f, err := ioutil.TempFile(s.dir, "file")
+	if err != nil {
+		return "", 0, err
+	}
+	w := &countWriter{w: f}
+	if err := write(w); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), w.n, nil
+}
+
+// A countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// evict removes the oldest stored files
+// until the total size of files is at most the server's maxSize.
+// It is called with the server locked.
+func (s *fileServer) evict() {
+	for s.size > s.maxSize {
+		var oldest *hostedFile
+		for _, f := range s.files {
+			if f.path != "" && (oldest == nil || f.expires.Before(oldest.expires)) {
+				oldest = f
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		s.remove(oldest)
+		oldest.err = errEvicted
+	}
+}
+
+// remove removes a stored file from the server's directory.
+// It is called with the server locked.
+func (s *fileServer) remove(f *hostedFile) {
+	os.Remove(f.path)
+	s.size -= f.size
+	f.path, f.size = "", 0
+}
+
+// prune removes expired files.
+// Files still being stored are removed once they are stored.
+func (s *fileServer) prune() {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for token, f := range s.files {
+		select {
+		case <-f.ready:
+		default:
+			continue
+		}
+		if now.After(f.expires) {
+			if f.path != "" {
+				s.remove(f)
+			}
+			delete(s.files, token)
+		}
+	}
+}
+
+// inlineTypes are the MIME types served for display in the browser.
+// Other files are served as attachments,
+// so that they do not run scripts from the server's origin.
+var inlineTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// ServeHTTP serves files at /token/name,
+// where name is path escaped.
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var token, name string
+	if p := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 2); len(p) == 2 {
+		token = p[0]
+		name, _ = url.PathUnescape(p[1])
+	}
+	s.Lock()
+	f, ok := s.files[token]
+	s.Unlock()
+	if !ok || name != f.name || time.Now().After(f.expires) {
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case <-f.ready:
+	case <-r.Context().Done():
+		return
+	}
+	s.Lock()
+	stored, err := f.path, f.err
+	s.Unlock()
+	if err != nil {
+		if f.fallback == "" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, f.fallback, http.StatusFound)
+		return
+	}
+	file, err := os.Open(stored)
+	if err != nil {
+		log.Println("file server failed to open file:", err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+	mediatype, _, _ := mime.ParseMediaType(f.mimetype)
+	if f.mimetype != "" {
+		h.Set("Content-Type", f.mimetype)
+	}
+	if !inlineTypes[mediatype] {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": f.name}))
+	}
+	http.ServeContent(w, r, f.name, time.Time{}, file)
+}
+
+// byteSize returns a human-readable size, for example, 120 KB.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	v := float64(n) / float64(div)
+	if v >= 10 {
+		return fmt.Sprintf("%.0f %cB", v, "KMGTPE"[exp])
+	}
+	return fmt.Sprintf("%.1f %cB", v, "KMGTPE"[exp])
+}
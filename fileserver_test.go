@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileServer(t *testing.T) {
+	s, err := newFileServer("http://files.example.com/", time.Hour)
+	if err != nil {
+		t.Fatalf("newFileServer failed: %v", err)
+	}
+	defer s.close()
+	url, err := s.add("../image.png", "image/png", "", func(w io.Writer) error {
+		_, err := io.WriteString(w, "png data")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	if !strings.HasPrefix(url, "http://files.example.com/") || !strings.HasSuffix(url, "/image.png") {
+		t.Errorf("add()=%q, want http://files.example.com/<token>/image.png", url)
+	}
+	path := strings.TrimPrefix(url, "http://files.example.com")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	if w.Code != http.StatusOK || string(body) != "png data" {
+		t.Errorf("GET %s=%d %q, want 200 %q", path, w.Code, body, "png data")
+	}
+	for k, v := range map[string]string{
+		"Content-Type":            "image/png",
+		"Content-Disposition":     "",
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	} {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s=%q, want %q", k, got, v)
+		}
+	}
+
+	url, _ = s.add("page.html", "text/html", "", func(w io.Writer) error {
+		_, err := io.WriteString(w, "<script>")
+		return err
+	})
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", strings.TrimPrefix(url, "http://files.example.com"), nil))
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=page.html` {
+		t.Errorf("Content-Disposition=%q, want attachment; filename=page.html", cd)
+	}
+
+	url, _ = s.add("big.png", "image/png", "https://slack.example.com/big.png", func(w io.Writer) error {
+		return errors.New("too big")
+	})
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", strings.TrimPrefix(url, "http://files.example.com"), nil))
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "https://slack.example.com/big.png" {
+		t.Errorf("GET failed file=%d %q, want 302 https://slack.example.com/big.png", w.Code, loc)
+	}
+
+	const name = "Screenshot 2024-01-01 at 10.00 #1?100%é.png"
+	url, _ = s.add(name, "image/png", "", func(w io.Writer) error { return nil })
+	if want := "/Screenshot%202024-01-01%20at%2010.00%20%231%3F100%25%C3%A9.png"; !strings.HasSuffix(url, want) {
+		t.Errorf("add(%q)=%q, want a URL ending in %s", name, url, want)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", strings.TrimPrefix(url, "http://files.example.com"), nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET %s=%d, want 200", url, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/badtoken/image.png", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET with a bad token=%d, want 404", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", path+"x", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET with a bad name=%d, want 404", w.Code)
+	}
+
+	s.ttl = -time.Second
+	url, _ = s.add("old.txt", "text/plain", "", func(w io.Writer) error { return nil })
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", strings.TrimPrefix(url, "http://files.example.com"), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET expired file=%d, want 404", w.Code)
+	}
+	<-s.files[strings.Split(url, "/")[3]].ready
+	s.prune()
+	if len(s.files) != 4 {
+		t.Errorf("%d files after prune, want 4", len(s.files))
+	}
+	s.close()
+	if _, err := os.Stat(s.dir); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s) after close: %v, want not exist", s.dir, err)
+	}
+}
+
+func TestFileServerEvict(t *testing.T) {
+	s, err := newFileServer("http://files.example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("newFileServer failed: %v", err)
+	}
+	defer s.close()
+	s.maxSize = 10
+	var paths []string
+	for i, name := range []string{"one.txt", "two.txt", "three.txt"} {
+		url, _ := s.add(name, "text/plain", "https://slack.example.com/"+name, func(w io.Writer) error {
+			_, err := io.WriteString(w, "12345")
+			return err
+		})
+		path := strings.TrimPrefix(url, "http://files.example.com")
+		paths = append(paths, path)
+		// Wait for the file to be stored, so they are stored in order.
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET file %d=%d, want 200", i, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", paths[0], nil))
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "https://slack.example.com/one.txt" {
+		t.Errorf("GET evicted file=%d %q, want 302 https://slack.example.com/one.txt", w.Code, loc)
+	}
+	for _, path := range paths[1:] {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s=%d, want 200", path, w.Code)
+		}
+	}
+	s.Lock()
+	size := s.size
+	s.Unlock()
+	if size != 10 {
+		t.Errorf("size=%d, want 10", size)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{120 * 1024, "120 KB"},
+		{5 * 1024 * 1024 / 2, "2.5 MB"},
+	}
+	for _, test := range tests {
+		if got := byteSize(test.n); got != test.want {
+			t.Errorf("byteSize(%d)=%q, want %q", test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/velour/relay
+
+go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/net v0.20.0
+)
+
+require golang.org/x/sys v0.16.0 // indirect
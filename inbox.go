@@ -0,0 +1,84 @@
+package main
+
+import "sync"
+
+// An inbox is a bounded queue of the slack events routed to an endpoint,
+// which the endpoint handles on its own goroutine,
+// so that an endpoint slow to handle events
+// stalls neither the workspace's reader nor the other endpoints.
+// If the inbox is full, the oldest event is dropped;
+// it cannot wait for room without stalling the workspace.
+type inbox struct {
+	n int
+	// ready receives a value when an event is put in the inbox
+	// or the inbox is closed.
+	ready chan struct{}
+
+	sync.Mutex
+	events  []map[string]interface{}
+	closed  bool
+	dropped int
+}
+
+func newInbox(n int) *inbox {
+	return &inbox{n: n, ready: make(chan struct{}, 1)}
+}
+
+// put adds an event to the inbox, dropping the oldest if it is full.
+func (in *inbox) put(event map[string]interface{}) {
+	in.Lock()
+	if len(in.events) >= in.n {
+		in.events[0] = nil
+		in.events = in.events[1:]
+		in.dropped++
+	}
+	in.events = append(in.events, event)
+	in.Unlock()
+	notify(in.ready)
+}
+
+// close closes the inbox.
+// Events already in the inbox may still be taken.
+func (in *inbox) close() {
+	in.Lock()
+	in.closed = true
+	in.Unlock()
+	notify(in.ready)
+}
+
+// take removes and returns the oldest event in the inbox
+// and the number of events dropped since the last take,
+// waiting for an event if the inbox is empty.
+// It returns false if the inbox is closed and empty, or stop is closed.
+func (in *inbox) take(stop <-chan struct{}) (map[string]interface{}, int, bool) {
+	for {
+		in.Lock()
+		if len(in.events) > 0 {
+			event := in.events[0]
+			in.events[0] = nil
+			in.events = in.events[1:]
+			dropped := in.dropped
+			in.dropped = 0
+			in.Unlock()
+			return event, dropped, true
+		}
+		closed := in.closed
+		in.Unlock()
+		if closed {
+			return nil, 0, false
+		}
+		select {
+		case <-in.ready:
+		case <-stop:
+			return nil, 0, false
+		}
+	}
+}
+
+// notify sends to a channel with a buffer of one, unless it is full.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInbox(t *testing.T) {
+	in := newInbox(2)
+	for _, text := range []string{"one", "two", "three"} {
+		// put never waits, even when the inbox is full.
+		in.put(map[string]interface{}{"text": text})
+	}
+	in.close()
+	var got []string
+	var dropped int
+	for {
+		event, n, ok := in.take(nil)
+		if !ok {
+			break
+		}
+		got = append(got, event["text"].(string))
+		dropped += n
+	}
+	if len(got) != 2 || got[0] != "two" || got[1] != "three" || dropped != 1 {
+		t.Errorf("took %v, dropped %d, want [two three], dropped 1", got, dropped)
+	}
+
+	in = newInbox(2)
+	stop := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		_, _, ok := in.take(stop)
+		done <- ok
+	}()
+	close(stop)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("take after stop returned an event")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("take did not return after stop")
+	}
+}
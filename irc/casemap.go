@@ -0,0 +1,28 @@
+package irc
+
+// Case-insensitive comparison of nicks and channel names
+// as specified in RFC 1459.
+
+import "strings"
+
+// casemap maps the upper-case letters of RFC 1459 to their lower case.
+// The characters {}|^ are the lower case of []\~.
+var casemap = strings.NewReplacer(
+	"A", "a", "B", "b", "C", "c", "D", "d", "E", "e", "F", "f", "G", "g",
+	"H", "h", "I", "i", "J", "j", "K", "k", "L", "l", "M", "m", "N", "n",
+	"O", "o", "P", "p", "Q", "q", "R", "r", "S", "s", "T", "t", "U", "u",
+	"V", "v", "W", "w", "X", "x", "Y", "y", "Z", "z",
+	"[", "{", "]", "}", "\\", "|", "~", "^",
+)
+
+// Lower returns a nick or channel name in lower case,
+// so that names that differ only in case are equal.
+func Lower(name string) string {
+	return casemap.Replace(name)
+}
+
+// EqualFold returns whether two nicks or channel names
+// are equal, ignoring case.
+func EqualFold(a, b string) bool {
+	return Lower(a) == Lower(b)
+}
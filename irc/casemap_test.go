@@ -0,0 +1,23 @@
+package irc
+
+import "testing"
+
+func TestEqualFold(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"relay", "relay", true},
+		{"Relay", "rELAY", true},
+		{"nick[away]", "NICK{AWAY}", true},
+		{`a\b~`, "A|B^", true},
+		{"#Go-Nuts", "#go-nuts", true},
+		{"relay", "relay_", false},
+		{"Ärger", "ärger", false},
+	}
+	for _, test := range tests {
+		if got := EqualFold(test.a, test.b); got != test.want {
+			t.Errorf("EqualFold(%q, %q)=%v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
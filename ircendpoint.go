@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/velour/relay/bridge"
+	"github.com/velour/relay/irc"
+)
+
+// An ircEndpoint is a bridge's IRC channel.
+type ircEndpoint struct {
+	network *network
+	cfg     *bridgeConfig
+	// format formats the text of attributed messages.
+	format *template.Template
+	events chan bridge.Event
+	// done is closed when the bridge stops reading events.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// epoch and seq form the IDs of messages,
+	// which are unique across restarts, since they may be stored.
+	// seq and last are used only by the network's goroutine.
+	epoch string
+	seq   int
+	// last is the last message of each nick, for corrections.
+	last map[string]ircMessage
+
+	// partial is how much of an event was sent
+	// before the connection failed,
+	// so that it is not sent again when the event is retried.
+	// It is used only by the bridge's goroutine that calls Send.
+	partial progress
+}
+
+// A progress is how much of an event was sent.
+type progress struct {
+	// key identifies the event.
+	key string
+	// puppet is whether the event's text was said by a puppet.
+	puppet bool
+	// lines is the number of lines sent.
+	lines int
+}
+
+// prefixLen is the room left in each line
+// for the prefix that the server adds when relaying it,
+// ":nick!user@host ".
+const prefixLen = 100
+
+// An ircMessage is a message said on IRC.
+type ircMessage struct {
+	id   string
+	text string
+}
+
+// newIRCEndpoint returns the endpoint of the bridge's IRC channel on network n.
+func newIRCEndpoint(n *network, cfg *bridgeConfig) (*ircEndpoint, error) {
+	format, err := template.New("format").Parse(cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	return &ircEndpoint{
+		network: n,
+		cfg:     cfg,
+		format:  format,
+		events:  make(chan bridge.Event),
+		done:    make(chan struct{}),
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		last:    make(map[string]ircMessage),
+	}, nil
+}
+
+func (ep *ircEndpoint) String() string {
+	return "irc " + ep.network.name + " " + ep.cfg.IRCChannel
+}
+
+// Connect joins the IRC channel.
+func (ep *ircEndpoint) Connect() error {
+	return ep.network.join(ep.cfg.IRCChannel, ep)
+}
+
+// Events returns the events of the IRC channel.
+func (ep *ircEndpoint) Events() <-chan bridge.Event {
+	return ep.events
+}
+
+// Capabilities returns that IRC can set topics,
+// but cannot edit, delete, or react to messages.
+func (ep *ircEndpoint) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Topic: true}
+}
+
+// Close leaves the IRC channel,
+// unless another bridge uses it.
+func (ep *ircEndpoint) Close() error {
+	ep.closeOnce.Do(func() {
+		close(ep.done)
+		ep.network.leave(ep.cfg.IRCChannel, ep)
+	})
+	return nil
+}
+
+// Send sends an event to the IRC channel.
+// Messages from slack users other than the relay's own
+// are said by the user's puppet, if the network has puppets,
+// and are otherwise attributed to the user.
+// Errors writing to the server mean that the network is reconnecting,
+// so they are returned as bridge.UnavailableErrors,
+// and the lines sent before the error are not sent again on retry.
+func (ep *ircEndpoint) Send(e bridge.Event) (string, error) {
+	c, channel := ep.network.conn(), ep.cfg.IRCChannel
+	if e.Kind == bridge.Topic {
+		if err := c.Send(irc.TOPIC, channel, e.Text); err != nil {
+			return "", sendError(err)
+		}
+		if err := c.Send(irc.NOTICE, channel, bridge.Render(e)); err != nil {
+			return "", sendError(err)
+		}
+		return "", nil
+	}
+	p := progress{key: fmt.Sprint(e.Kind, e.ID, e.From, e.Time.UnixNano())}
+	if ep.partial.key == p.key {
+		p = ep.partial
+	}
+	ep.partial = progress{}
+	if e.Kind == bridge.Message || e.Kind == bridge.Action {
+		if !p.puppet {
+			p.puppet = ep.puppetSay(e)
+		}
+		if p.puppet {
+			// Only the attachments remain to be relayed.
+			e.Text = ""
+		}
+	}
+	lines := ep.lines(e)
+	for ; p.lines < len(lines); p.lines++ {
+		if err := c.Send(irc.PRIVMSG, channel, lines[p.lines]); err != nil {
+			err = sendError(err)
+			if _, ok := err.(bridge.UnavailableError); ok {
+				ep.partial = p
+			}
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// sendError returns an error sending to the IRC server
+// as a bridge.UnavailableError,
+// unless the message itself was at fault.
+func sendError(err error) error {
+	if _, ok := err.(irc.TooLongError); ok {
+		return err
+	}
+	return bridge.UnavailableError{Err: err}
+}
+
+// puppetSay says the text of a message by its sender's puppet,
+// if the network has puppets and the sender may have one.
+// It returns whether the puppet said it.
+func (ep *ircEndpoint) puppetSay(e bridge.Event) bool {
+	if ep.network.puppets == nil || e.Self || e.Bot || e.UserID == "" || e.Text == "" {
+		return false
+	}
+	t := &puppetText{userID: e.UserID, name: e.From, text: e.Body(), action: e.Kind == bridge.Action}
+	if err := ep.network.puppets.say(ep.network, t, ep.cfg.IRCChannel); err != nil {
+		// Relay the message from the relay's own nick.
+		log.Println("irc puppet failed:", err)
+		return false
+	}
+	return true
+}
+
+// lines returns the IRC lines of an event,
+// followed by a line announcing each of its attachments.
+// Attachments and blocks often span several lines,
+// but an IRC message cannot,
+// and lines too long for an IRC message are split.
+func (ep *ircEndpoint) lines(e bridge.Event) []string {
+	var text string
+	var action bool
+	switch {
+	case e.Kind != bridge.Message && e.Kind != bridge.Action:
+		text = bridge.Render(e)
+	case e.Self:
+		text, action = e.Body(), e.Kind == bridge.Action
+	case e.Text != "":
+		// The relay speaks on IRC as its user or as itself,
+		// so attribute others' text to them.
+		text = ep.attribute(e)
+	}
+	maxLen := lineLen(ep.cfg.IRCChannel)
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+		if !action {
+			lines = append(lines, splitLine(line, maxLen)...)
+			continue
+		}
+		for _, l := range splitLine(line, maxLen-len("\x01ACTION \x01")) {
+			lines = append(lines, "\x01ACTION "+l+"\x01")
+		}
+	}
+	for _, a := range e.Attachments {
+		line := fmt.Sprintf("%s shared %s (%s): %s", e.From, a.Name, byteSize(a.Size), a.URL)
+		lines = append(lines, splitLine(line, maxLen)...)
+	}
+	return lines
+}
+
+// lineLen returns the maximum length in bytes of a line said in an IRC channel.
+func lineLen(channel string) int {
+	return irc.MaxBytes - len("PRIVMSG "+channel+" :\r\n") - prefixLen
+}
+
+// splitLine splits a line into lines of at most n bytes,
+// at spaces where it can, and otherwise between runes.
+func splitLine(line string, n int) []string {
+	var lines []string
+	for len(line) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		if j := strings.LastIndexByte(line[:i], ' '); j > 0 {
+			i = j
+		}
+		lines = append(lines, strings.TrimRight(line[:i], " "))
+		line = strings.TrimLeft(line[i:], " ")
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// A formatData is the data of a bridge's format template.
+type formatData struct {
+	// Name is the display name of the sender.
+	Name string
+	// User is the username of a slack user,
+	// or empty for a bot.
+	User string
+	// ID is the user or bot ID of the sender.
+	ID   string
+	Text string
+}
+
+const defaultFormat = "<{{.Name}}> {{.Text}}"
+
+// attribute returns the text of e with its sender's name.
+// Actions are written "* who text";
+// other messages are formatted with the format template, a line at a time.
+func (ep *ircEndpoint) attribute(e bridge.Event) string {
+	if e.Kind == bridge.Action {
+		return bridge.Render(e)
+	}
+	data := formatData{Name: e.From, User: e.User, ID: e.UserID}
+	lines := strings.Split(e.Body(), "\n")
+	for i, line := range lines {
+		data.Text = line
+		var b strings.Builder
+		if err := ep.format.Execute(&b, data); err != nil {
+			log.Println("failed to format slack message:", err)
+			return "<" + e.From + "> " + e.Body()
+		}
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// receive reports an event in the IRC channel.
+// A message of the form s/old/new/ is reported
+// as an edit of the sender's last message,
+// or as its deletion if the corrected text is empty.
+func (ep *ircEndpoint) receive(e bridge.Event) {
+	if e.Kind == bridge.Message {
+		if s, ok := parseSubst(e.Text); ok {
+			if last, ok := ep.last[e.From]; ok {
+				if text, ok := s.apply(last.text); ok {
+					ep.correct(e, last, text)
+					return
+				}
+			}
+		}
+	}
+	if e.Kind == bridge.Message || e.Kind == bridge.Action {
+		ep.seq++
+		e.ID = ep.epoch + "." + strconv.Itoa(ep.seq)
+		ep.last[e.From] = ircMessage{id: e.ID, text: e.Text}
+	}
+	ep.send(e)
+}
+
+// correct reports the correction of the last message of e's sender to text.
+func (ep *ircEndpoint) correct(e bridge.Event, last ircMessage, text string) {
+	e.Target = bridge.Ref{ID: last.id, From: e.From, Text: last.text}
+	if strings.TrimSpace(text) == "" {
+		delete(ep.last, e.From)
+		e.Kind, e.Text = bridge.Delete, ""
+	} else {
+		ep.last[e.From] = ircMessage{id: last.id, text: text}
+		e.Kind, e.Text = bridge.Edit, text
+	}
+	ep.send(e)
+}
+
+// topicFailed reports an error from the IRC server setting the topic,
+// if the bridge mirrors topics.
+func (ep *ircEndpoint) topicFailed(server, reason string) {
+	if ep.cfg.TopicSync == topicNone {
+		return
+	}
+	ep.send(bridge.Event{Kind: bridge.Notice, Network: server, Time: time.Now(), Text: "could not set the IRC topic: " + reason})
+}
+
+// send reports an event, unless the bridge has stopped.
+func (ep *ircEndpoint) send(e bridge.Event) {
+	select {
+	case ep.events <- e:
+	case <-ep.done:
+	}
+}
+
+// disconnect closes the events channel
+// when the network's connection fails.
+func (ep *ircEndpoint) disconnect() {
+	close(ep.events)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velour/relay/bridge"
+	"github.com/velour/relay/irc"
+)
+
+// A network is a connection to an IRC network shared by all bridges on it.
+// It routes events from each IRC channel to the endpoints of the channel.
+// If the connection fails, the network redials the server
+// and rejoins its channels.
+type network struct {
+	name string
+	cfg  *ircConfig
+	// puppets, if non-nil, supervises the network's puppets.
+	puppets *supervisor
+	// done is closed when the network is closed.
+	done chan struct{}
+
+	sync.Mutex
+	client *irc.Client
+	// routes are the endpoints of each IRC channel,
+	// keyed by the channel name in lower case; see irc.Lower.
+	routes map[string][]*ircEndpoint
+}
+
+// maxRedialBackoff is the maximum delay between attempts
+// to redial an IRC server.
+const maxRedialBackoff = 5 * time.Minute
+
+func dialIRC(name string, cfg *ircConfig) (*network, error) {
+	c, err := dialServer(cfg, cfg.Nick, cfg.FullName)
+	if err != nil {
+		return nil, fmt.Errorf("irc failed to dial: %v", err)
+	}
+	n := &network{
+		name:   name,
+		cfg:    cfg,
+		done:   make(chan struct{}),
+		client: c,
+		routes: make(map[string][]*ircEndpoint),
+	}
+	go n.run()
+	return n, nil
+}
+
+// conn returns the network's current client.
+func (n *network) conn() *irc.Client {
+	n.Lock()
+	defer n.Unlock()
+	return n.client
+}
+
+// close closes the network's connection
+// and disconnects its endpoints.
+func (n *network) close() {
+	close(n.done)
+	n.conn().Close()
+}
+
+// redial redials the server, with backoff, after the connection failed,
+// and rejoins the channels of the network's endpoints.
+// It returns false if the network was closed.
+func (n *network) redial() bool {
+	n.conn().Close()
+	backoff := time.Second
+	for {
+		select {
+		case <-n.done:
+			return false
+		case <-time.After(backoff):
+		}
+		c, err := dialServer(n.cfg, n.cfg.Nick, n.cfg.FullName)
+		if err != nil {
+			log.Printf("irc %s failed to redial: %v", n.name, err)
+			if backoff *= 2; backoff > maxRedialBackoff {
+				backoff = maxRedialBackoff
+			}
+			continue
+		}
+		n.Lock()
+		n.client = c
+		for key := range n.routes {
+			if err := c.Send(irc.JOIN, key); err != nil {
+				log.Println("irc failed to send JOIN:", err)
+			}
+		}
+		n.Unlock()
+		log.Printf("irc %s reconnected", n.name)
+		return true
+	}
+}
+
+// join joins the IRC channel, if not already joined,
+// and routes its events to ep.
+func (n *network) join(channel string, ep *ircEndpoint) error {
+	key := irc.Lower(channel)
+	n.Lock()
+	defer n.Unlock()
+	if len(n.routes[key]) == 0 {
+		if err := n.client.Send(irc.JOIN, channel); err != nil {
+			return fmt.Errorf("irc failed to send JOIN: %v", err)
+		}
+	}
+	n.routes[key] = append(n.routes[key], ep)
+	return nil
+}
+
+// leave stops routing the IRC channel's events to ep,
+// and parts the channel if no other endpoint uses it.
+func (n *network) leave(channel string, ep *ircEndpoint) {
+	key := irc.Lower(channel)
+	n.Lock()
+	defer n.Unlock()
+	eps := n.routes[key]
+	for i, e := range eps {
+		if e == ep {
+			eps = append(eps[:i:i], eps[i+1:]...)
+			break
+		}
+	}
+	if len(eps) > 0 {
+		n.routes[key] = eps
+		return
+	}
+	if _, ok := n.routes[key]; !ok {
+		// The connection failed.
+		return
+	}
+	delete(n.routes, key)
+	if err := n.client.Send(irc.PART, channel); err != nil {
+		log.Println("irc failed to send PART:", err)
+	}
+}
+
+// endpoints returns the endpoints of the IRC channel.
+func (n *network) endpoints(channel string) []*ircEndpoint {
+	n.Lock()
+	defer n.Unlock()
+	return n.routes[irc.Lower(channel)]
+}
+
+// route sends an event to the endpoints of the IRC channel.
+func (n *network) route(channel string, e bridge.Event) {
+	for _, ep := range n.endpoints(channel) {
+		ep.receive(e)
+	}
+}
+
+// run reads messages from IRC and routes them to endpoints,
+// redialing if the connection fails.
+// When the network is closed, it disconnects all endpoints.
+func (n *network) run() {
+	defer func() {
+		n.Lock()
+		defer n.Unlock()
+		for key, eps := range n.routes {
+			for _, ep := range eps {
+				ep.disconnect()
+			}
+			delete(n.routes, key)
+		}
+	}()
+
+	nick := n.cfg.Nick
+	server := hostname(n.cfg.Server)
+	// event returns an event by who.
+	event := func(kind bridge.Kind, who, text string) bridge.Event {
+		return bridge.Event{Kind: kind, Network: server, Time: time.Now(), From: who, Text: text}
+	}
+	// arg returns the ith argument of msg, or the empty string.
+	arg := func(msg irc.Message, i int) string {
+		if i < len(msg.Arguments) {
+			return msg.Arguments[i]
+		}
+		return ""
+	}
+	// talkers is the time each nick last spoke in each channel,
+	// keyed by the lower-cased IRC channel name and nick.
+	talkers := make(map[string]map[string]time.Time)
+	// recent returns whether who has spoken in the channel within the hour.
+	recent := func(key, who string) bool {
+		return irc.EqualFold(who, nick) || time.Since(talkers[key][irc.Lower(who)]) <= time.Hour
+	}
+	// joined returns the lower-cased names of bridged channels
+	// in which who has spoken recently.
+	joined := func(who string) []string {
+		n.Lock()
+		defer n.Unlock()
+		var keys []string
+		for key := range n.routes {
+			if recent(key, who) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	for {
+		msg, err := n.conn().Next()
+		if err != nil {
+			select {
+			case <-n.done:
+				return
+			default:
+			}
+			log.Printf("irc %s read error: %v", n.name, err)
+			if !n.redial() {
+				return
+			}
+			continue
+		}
+		if n.puppets != nil && msg.Origin != "" && n.puppets.isPuppet(n.name, msg.Origin) {
+			// Puppets only say what was said on slack.
+			continue
+		}
+		switch msg.Command {
+		case irc.JOIN:
+			if len(msg.Arguments) < 1 {
+				break
+			}
+			who, channel := msg.Origin, msg.Arguments[0]
+			if !recent(irc.Lower(channel), who) {
+				break
+			}
+			n.route(channel, event(bridge.Join, who, ""))
+
+		case irc.NICK:
+			if len(msg.Arguments) < 1 {
+				break
+			}
+			who, to := msg.Origin, msg.Arguments[0]
+			for _, key := range joined(who) {
+				if ts, ok := talkers[key]; ok {
+					ts[irc.Lower(to)] = ts[irc.Lower(who)]
+				}
+				e := event(bridge.Nick, who, "")
+				e.Subject = to
+				n.route(key, e)
+			}
+
+		case irc.QUIT:
+			who := msg.Origin
+			for _, key := range joined(who) {
+				n.route(key, event(bridge.Quit, who, arg(msg, 0)))
+			}
+
+		case irc.PART:
+			if len(msg.Arguments) < 1 {
+				break
+			}
+			who, channel := msg.Origin, msg.Arguments[0]
+			if !recent(irc.Lower(channel), who) {
+				break
+			}
+			n.route(channel, event(bridge.Part, who, arg(msg, 1)))
+
+		case irc.KICK:
+			if len(msg.Arguments) < 2 {
+				break
+			}
+			e := event(bridge.Kick, msg.Origin, arg(msg, 2))
+			e.Subject = msg.Arguments[1]
+			n.route(msg.Arguments[0], e)
+
+		case irc.TOPIC:
+			if len(msg.Arguments) < 2 {
+				break
+			}
+			who, channel := msg.Origin, msg.Arguments[0]
+			if irc.EqualFold(who, nick) {
+				break
+			}
+			n.route(channel, event(bridge.Topic, who, msg.Arguments[1]))
+
+		case irc.ERR_CHANOPRIVSNEEDED:
+			if len(msg.Arguments) < 3 {
+				break
+			}
+			for _, ep := range n.endpoints(msg.Arguments[1]) {
+				ep.topicFailed(server, msg.Arguments[2])
+			}
+
+		case irc.PRIVMSG:
+			if len(msg.Arguments) < 2 {
+				break
+			}
+			who, channel, text := msg.Origin, msg.Arguments[0], msg.Arguments[1]
+			key := irc.Lower(channel)
+			if talkers[key] == nil {
+				talkers[key] = make(map[string]time.Time)
+			}
+			talkers[key][irc.Lower(who)] = time.Now()
+			if irc.EqualFold(who, nick) {
+				break
+			}
+			e := event(bridge.Message, who, text)
+			if strings.HasPrefix(text, "\x01ACTION ") {
+				e.Kind = bridge.Action
+				e.Text = strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+			}
+			n.route(channel, e)
+		default:
+			log.Printf("irc message:\n%#v\n\n", msg)
+		}
+	}
+}
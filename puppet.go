@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velour/relay/irc"
+)
+
+const (
+	// maxNickLen is the length to which puppet nicks are truncated.
+	// It is the NICKLEN of common IRC servers.
+	maxNickLen = 16
+
+	// reapInterval is how often idle puppets are disconnected.
+	reapInterval = time.Minute
+)
+
+// errPuppetLimit is returned by supervisor.say
+// when a new puppet would exceed its host's connection limit.
+var errPuppetLimit = errors.New("puppet connection limit reached")
+
+// A supervisor manages the puppets of all networks.
+// A puppet is an IRC connection for a single slack user,
+// which relays the user's messages under a nick derived from their name.
+// Puppets connect on the user's first message
+// and disconnect after being idle.
+type supervisor struct {
+	// dial connects to the network's server with the given nick.
+	dial func(cfg *ircConfig, nick, fullname string) (*irc.Client, error)
+
+	sync.Mutex
+	puppets map[puppetKey]*puppet
+	// hosts is the number of puppets connected to each host.
+	hosts map[string]int
+	done  chan struct{}
+}
+
+type puppetKey struct {
+	network string
+	userID  string
+}
+
+// A puppet is the IRC connection of a slack user.
+type puppet struct {
+	cfg  *ircConfig
+	nick string
+	// ready is closed once the puppet is connected, or failed to.
+	ready  chan struct{}
+	client *irc.Client
+	err    error
+
+	// last and joined are guarded by the supervisor.
+	last   time.Time
+	joined map[string]bool
+}
+
+// A puppetText is the text of a slack user's message,
+// without attribution, for their puppet to say.
+type puppetText struct {
+	userID string
+	name   string
+	text   string
+	action bool
+}
+
+func newSupervisor() *supervisor {
+	s := &supervisor{
+		dial:    dialServer,
+		puppets: make(map[puppetKey]*puppet),
+		hosts:   make(map[string]int),
+		done:    make(chan struct{}),
+	}
+	go s.reap()
+	return s
+}
+
+// dialServer connects to the network's server with the given nick.
+func dialServer(cfg *ircConfig, nick, fullname string) (*irc.Client, error) {
+	if cfg.SSL {
+		return irc.DialSSL(cfg.Server, nick, fullname, cfg.Password, false)
+	}
+	return irc.Dial(cfg.Server, nick, fullname, cfg.Password)
+}
+
+// say says the text in the IRC channel as the slack user's puppet
+// on network n, connecting the puppet if needed.
+// It returns errPuppetLimit if the puppet is not connected
+// and the host has the network's limit of puppets.
+func (s *supervisor) say(n *network, t *puppetText, channel string) error {
+	key := puppetKey{network: n.name, userID: t.userID}
+	host := hostname(n.cfg.Server)
+	s.Lock()
+	p, ok := s.puppets[key]
+	if !ok {
+		if s.hosts[host] >= n.cfg.PuppetLimit {
+			s.Unlock()
+			return errPuppetLimit
+		}
+		p = &puppet{
+			cfg:    n.cfg,
+			nick:   puppetNick(t.name, n.cfg.PuppetSuffix),
+			ready:  make(chan struct{}),
+			joined: make(map[string]bool),
+		}
+		s.puppets[key] = p
+		s.hosts[host]++
+		go s.connect(key, p, t.name)
+	}
+	p.last = time.Now()
+	s.Unlock()
+
+	<-p.ready
+	if p.err != nil {
+		return p.err
+	}
+	s.Lock()
+	join := !p.joined[irc.Lower(channel)]
+	p.joined[irc.Lower(channel)] = true
+	s.Unlock()
+	if join {
+		if err := p.client.Send(irc.JOIN, channel); err != nil {
+			return err
+		}
+	}
+	maxLen := lineLen(channel)
+	if t.action {
+		maxLen -= len("\x01ACTION \x01")
+	}
+	for _, text := range strings.Split(t.text, "\n") {
+		for _, line := range splitLine(text, maxLen) {
+			if t.action {
+				line = "\x01ACTION " + line + "\x01"
+			}
+			if err := p.client.Send(irc.PRIVMSG, channel, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// connect connects the puppet,
+// appending underscores to its nick while the nick is taken.
+func (s *supervisor) connect(key puppetKey, p *puppet, name string) {
+	defer close(p.ready)
+	nick := p.nick
+	for i := 0; i < 3; i++ {
+		p.client, p.err = s.dial(p.cfg, nick, name+" (slack)")
+		if p.err == nil {
+			break
+		}
+		nick += "_"
+	}
+	if p.err != nil {
+		p.err = fmt.Errorf("puppet %s failed to connect: %v", p.nick, p.err)
+		s.drop(key, p)
+		return
+	}
+	s.Lock()
+	p.nick = nick
+	s.Unlock()
+	log.Printf("irc %s puppet %s connected", key.network, nick)
+	go s.read(key, p)
+}
+
+// read reads messages from the puppet's connection,
+// which answers PINGs, until it fails.
+func (s *supervisor) read(key puppetKey, p *puppet) {
+	for {
+		if _, err := p.client.Next(); err != nil {
+			s.drop(key, p)
+			return
+		}
+	}
+}
+
+// drop forgets the puppet, if it is still the user's puppet.
+func (s *supervisor) drop(key puppetKey, p *puppet) {
+	s.Lock()
+	defer s.Unlock()
+	if s.puppets[key] != p {
+		return
+	}
+	delete(s.puppets, key)
+	s.hosts[hostname(p.cfg.Server)]--
+}
+
+// reap disconnects idle puppets until the supervisor is closed.
+func (s *supervisor) reap() {
+	tick := time.NewTicker(reapInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick.C:
+		}
+		s.Lock()
+		var idle []*puppet
+		for key, p := range s.puppets {
+			select {
+			case <-p.ready:
+			default:
+				// Still connecting.
+				continue
+			}
+			if time.Since(p.last) > p.cfg.PuppetIdle.Duration {
+				idle = append(idle, p)
+				delete(s.puppets, key)
+				s.hosts[hostname(p.cfg.Server)]--
+			}
+		}
+		s.Unlock()
+		for _, p := range idle {
+			log.Printf("irc puppet %s idle", p.nick)
+			p.client.Close()
+		}
+	}
+}
+
+// isPuppet returns whether the nick is a puppet's on the network.
+func (s *supervisor) isPuppet(network, nick string) bool {
+	s.Lock()
+	defer s.Unlock()
+	for key, p := range s.puppets {
+		if key.network == network && irc.EqualFold(p.nick, nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// close disconnects all puppets.
+func (s *supervisor) close() {
+	close(s.done)
+	s.Lock()
+	ps := s.puppets
+	s.puppets = make(map[puppetKey]*puppet)
+	s.hosts = make(map[string]int)
+	s.Unlock()
+	for _, p := range ps {
+		<-p.ready
+		if p.client != nil {
+			p.client.Close()
+		}
+	}
+}
+
+// hostname returns the host of a host:port address.
+func hostname(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// puppetNick returns the nick of the puppet of a slack user with the given name:
+// the name without characters that are not allowed in nicks,
+// truncated so that with the suffix it is at most maxNickLen bytes.
+func puppetNick(name, suffix string) string {
+	var nick strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', strings.ContainsRune("[]\\`_^{|}", r):
+		case (r >= '0' && r <= '9' || r == '-') && nick.Len() > 0:
+		case r == ' ' && nick.Len() > 0:
+			r = '_'
+		default:
+			continue
+		}
+		nick.WriteRune(r)
+	}
+	n := nick.String()
+	if n == "" {
+		n = "slack"
+	}
+	if max := maxNickLen - len(suffix); len(n) > max && max > 0 {
+		n = n[:max]
+	}
+	return n + suffix
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPuppetNick(t *testing.T) {
+	tests := []struct {
+		name, suffix, want string
+	}{
+		{"alice", "[s]", "alice[s]"},
+		{"Bobby Tables", "[s]", "Bobby_Tables[s]"},
+		{"2pac", "|s", "pac|s"},
+		{"Zoë", "", "Zo"},
+		{"名前", "[s]", "slack[s]"},
+		{"averyveryverylongname", "[s]", "averyveryvery[s]"},
+	}
+	for _, test := range tests {
+		if got := puppetNick(test.name, test.suffix); got != test.want {
+			t.Errorf("puppetNick(%q, %q)=%q, want %q", test.name, test.suffix, got, test.want)
+		}
+	}
+}
+
+// fakeIRCServer is a fake IRC server that welcomes every client
+// and sends the lines that it receives on a channel.
+func fakeIRCServer(t *testing.T) (addr string, lines <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				in := bufio.NewScanner(conn)
+				for in.Scan() {
+					line := strings.TrimSpace(in.Text())
+					ch <- line
+					if strings.HasPrefix(line, "USER ") {
+						conn.Write([]byte(":fake 001 puppet :Welcome\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func TestSupervisorSay(t *testing.T) {
+	addr, lines := fakeIRCServer(t)
+	n := &network{
+		name: "test",
+		cfg: &ircConfig{
+			Server:       addr,
+			Puppets:      true,
+			PuppetSuffix: "[s]",
+			PuppetIdle:   duration{time.Hour},
+			PuppetLimit:  1,
+		},
+	}
+	s := newSupervisor()
+	defer s.close()
+
+	if err := s.say(n, &puppetText{userID: "U1", name: "alice", text: "hi"}, "#test"); err != nil {
+		t.Fatalf("say failed: %v", err)
+	}
+	want := []string{"NICK :alice[s]", "USER alice[s] 0 * :alice (slack)", "JOIN :#test", "PRIVMSG #test :hi"}
+	for _, w := range want {
+		select {
+		case line := <-lines:
+			if line != w {
+				t.Errorf("server got %q, want %q", line, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+	if !s.isPuppet("test", "Alice[s]") {
+		t.Errorf("isPuppet(Alice[s])=false, want true")
+	}
+	if err := s.say(n, &puppetText{userID: "U2", name: "bob", text: "hi"}, "#test"); err != errPuppetLimit {
+		t.Errorf("say as a second puppet=%v, want errPuppetLimit", err)
+	}
+}
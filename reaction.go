@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velour/relay/bridge"
+)
+
+// reactionWindow is how long reactions to a message
+// are collected before they are relayed in a single line.
+const reactionWindow = 5 * time.Second
+
+// reactions debounces slack reactions to relayed messages,
+// relaying bursts of reactions to a message as a single IRC line.
+// It is safe for concurrent use.
+type reactions struct {
+	window time.Duration
+	ch     chan<- bridge.Event
+	// done, if non-nil, is closed when ch is no longer read.
+	done <-chan struct{}
+
+	sync.Mutex
+	closed  bool
+	pending map[string]*reactionBurst
+	// sending counts the flushes sending to ch.
+	sending sync.WaitGroup
+}
+
+// A reactionBurst is the reactions to a message within the window.
+type reactionBurst struct {
+	target recent
+	// whos are the reacting users in order of their first reaction.
+	whos []string
+	// added and removed map a reacting user to their reactions.
+	added   map[string][]string
+	removed map[string][]string
+}
+
+func newReactions(ch chan<- bridge.Event) *reactions {
+	return &reactions{
+		window:  reactionWindow,
+		ch:      ch,
+		pending: make(map[string]*reactionBurst),
+	}
+}
+
+// add records a reaction by who to the message target with timestamp ts.
+// If removed is true, the reaction was removed.
+// Removing a reaction cancels the pending addition of the same reaction.
+func (rs *reactions) add(ts string, target recent, who, reaction string, removed bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.closed {
+		return
+	}
+	b, ok := rs.pending[ts]
+	if !ok {
+		b = &reactionBurst{
+			target:  target,
+			added:   make(map[string][]string),
+			removed: make(map[string][]string),
+		}
+		rs.pending[ts] = b
+		time.AfterFunc(rs.window, func() { rs.flush(ts) })
+	}
+	if _, ok := b.added[who]; !ok {
+		if _, ok := b.removed[who]; !ok {
+			b.whos = append(b.whos, who)
+		}
+	}
+	if removed {
+		if !cancel(b.added, who, reaction) {
+			b.removed[who] = append(b.removed[who], reaction)
+		}
+	} else {
+		if !cancel(b.removed, who, reaction) {
+			b.added[who] = append(b.added[who], reaction)
+		}
+	}
+}
+
+// cancel removes reaction from the reactions of who,
+// and reports whether it was there.
+func cancel(m map[string][]string, who, reaction string) bool {
+	rs := m[who]
+	for i, r := range rs {
+		if r == reaction {
+			m[who] = append(rs[:i:i], rs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// flush relays the pending reactions to the message with timestamp ts.
+// The event is sent without the lock held,
+// so that a slow reader does not block later reactions.
+func (rs *reactions) flush(ts string) {
+	rs.Lock()
+	b, ok := rs.pending[ts]
+	delete(rs.pending, ts)
+	if !ok || rs.closed {
+		rs.Unlock()
+		return
+	}
+	text := b.String()
+	if text == "" {
+		rs.Unlock()
+		return
+	}
+	rs.sending.Add(1)
+	defer rs.sending.Done()
+	rs.Unlock()
+	select {
+	case rs.ch <- bridge.Event{Kind: bridge.Reaction, Time: time.Now(), Target: bridge.Ref{ID: ts, From: b.target.who, Text: b.target.text}, Text: text}:
+	case <-rs.done:
+	}
+}
+
+// close stops relaying reactions,
+// and waits for reactions being sent,
+// so that ch may be closed once it returns.
+// Pending reactions are dropped.
+func (rs *reactions) close() {
+	rs.Lock()
+	rs.closed = true
+	rs.Unlock()
+	rs.sending.Wait()
+}
+
+// String returns the IRC line describing the reactions,
+// for example, "alice reacted :+1: to bob: 'first words…'",
+// or the empty string if all reactions were cancelled.
+func (b *reactionBurst) String() string {
+	var parts []string
+	for _, who := range b.whos {
+		if rs := b.added[who]; len(rs) > 0 {
+			parts = append(parts, who+" reacted "+strings.Join(rs, " "))
+		}
+		if rs := b.removed[who]; len(rs) > 0 {
+			parts = append(parts, who+" removed "+strings.Join(rs, " "))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, ", ") + " to " + bridge.Quote(b.target.who, b.target.text)
+}
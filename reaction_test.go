@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/velour/relay/bridge"
+)
+
+func TestReactions(t *testing.T) {
+	ch := make(chan bridge.Event, 10)
+	rs := newReactions(ch)
+	rs.window = 10 * time.Millisecond
+	bob := recent{who: "bob", text: "first words of a long message to react to"}
+
+	rs.add("1.1", bob, "alice", ":+1:", false)
+	rs.add("1.1", bob, "carol", ":tada:", false)
+	rs.add("1.1", bob, "alice", ":eyes:", false)
+	rs.add("1.1", bob, "carol", ":tada:", true)
+	rs.add("1.1", bob, "carol", ":heart:", false)
+	select {
+	case msg := <-ch:
+		want := "alice reacted :+1: :eyes:, carol reacted :heart: to bob: 'first words of a long message…'"
+		if msg.Kind != bridge.Reaction || msg.Target.ID != "1.1" || msg.Text != want {
+			t.Errorf("got %+v, want reaction to 1.1 %q", msg, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reactions")
+	}
+
+	rs.add("1.2", bob, "alice", ":+1:", false)
+	rs.add("1.2", bob, "alice", ":+1:", true)
+	select {
+	case msg := <-ch:
+		t.Errorf("got %q for cancelled reactions, want nothing", msg.Text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReactionsSlowReader(t *testing.T) {
+	ch := make(chan bridge.Event)
+	rs := newReactions(ch)
+	rs.window = time.Millisecond
+	bob := recent{who: "bob", text: "hi"}
+
+	rs.add("1.1", bob, "alice", ":+1:", false)
+	// Let the flush block sending to ch.
+	time.Sleep(20 * time.Millisecond)
+	added := make(chan struct{})
+	go func() {
+		rs.add("1.2", bob, "carol", ":tada:", false)
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("add blocked while a flush waited for the reader")
+	}
+	for _, id := range []string{"1.1", "1.2"} {
+		select {
+		case msg := <-ch:
+			if msg.Target.ID != id {
+				t.Errorf("got reaction to %s, want %s", msg.Target.ID, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for reaction to %s", id)
+		}
+	}
+}
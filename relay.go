@@ -1,30 +1,68 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"html"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"os/user"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/velour/relay/irc"
+	"github.com/velour/relay/bridge"
 	"github.com/velour/relay/slack"
+	"github.com/velour/relay/store"
 )
 
 var (
-	ircServer   = flag.String("ircserver", "irc.freenode.net:7000", "The IRC host and port")
-	ircSSL      = flag.Bool("ircssl", true, "Whether to use SSL to connect to the IRC server")
-	ircPassword = flag.String("ircpassword", "", "The password for the IRC server")
-	ircNick     = flag.String("ircnick", nick(), "The IRC nick name")
-	ircFullName = flag.String("ircfullname", fullname(), "The IRC full name")
-	ircChannel  = flag.String("ircchannel", "", "The IRNC channel to relay")
+	ircServer    = flag.String("ircserver", "irc.freenode.net:7000", "The IRC host and port")
+	ircSSL       = flag.Bool("ircssl", true, "Whether to use SSL to connect to the IRC server")
+	ircPassword  = flag.String("ircpassword", "", "The password for the IRC server")
+	ircNick      = flag.String("ircnick", nick(), "The IRC nick name")
+	ircFullName  = flag.String("ircfullname", fullname(), "The IRC full name")
+	ircChannel   = flag.String("ircchannel", "", "The IRNC channel to relay")
+	topicMode    = flag.String("topicsync", topicNone, "How to mirror channel topics: none, both, irc-to-slack, or slack-to-irc")
+	ircPuppets   = flag.Bool("ircpuppets", false, "Whether to relay each slack user to IRC by a puppet: an IRC connection of their own")
+	puppetSuffix = flag.String("ircpuppetsuffix", "[s]", "The suffix of the nicks of puppets if -ircpuppets")
+	puppetIdle   = flag.Duration("ircpuppetidle", 30*time.Minute, "How long a puppet may be idle before it disconnects if -ircpuppets")
+	puppetLimit  = flag.Int("ircpuppetlimit", 5, "The maximum number of puppets connected to the IRC host if -ircpuppets")
+	asciiEmoji   = flag.Bool("asciiemoji", false, "Whether to convert ASCII smileys from IRC, such as :), to emoji")
 )
 
 var (
+	slackAPI     = flag.String("slackapi", "https://slack.com/api", "The slack Web API base URL")
 	slackToken   = flag.String("slacktoken", "", "The slack token")
 	slackNick    = flag.String("slacknick", nick(), "The username to relay into IRC")
-	slackChannel = flag.String("slackchannel", "", "The channel (with # prefix) or private channel (no # prefix)")
+	slackBlocks  = flag.Bool("slackblocks", true, "Whether to render IRC notices, such as joins, with slack Block Kit blocks")
+	slackChannel = flag.String("slackchannel", "", "The name or ID of the slack channel to relay")
+	slackDeletes = flag.Bool("slackdeletes", false, "Whether to relay a notice to IRC when a relayed slack message is deleted")
+	slackFiles   = flag.String("slackfiles", "permalink", "How to relay slack files to IRC: permalink, host, or none")
+	fileServe    = flag.String("fileserver", ":8080", "The address on which to serve files if -slackfiles=host")
+	fileURL      = flag.String("fileurl", "", "The public base URL of the file server if -slackfiles=host")
+	fileTTL      = flag.Duration("filettl", 24*time.Hour, "How long files are served if -slackfiles=host")
+	slackReacts  = flag.Bool("slackreactions", true, "Whether to relay slack reactions to relayed messages to IRC")
+	slackBots    = flag.Bool("slackbots", true, "Whether to relay slack bot messages, other than the relay's own, to IRC")
+	slackShared  = flag.Bool("slackshared", false, "Whether to relay all slack channel members to IRC, rather than only -slacknick")
+	slackAllow   = flag.String("slackallow", "", "A comma-separated list of the only slack users to relay, if not empty")
+	slackDeny    = flag.String("slackdeny", "", "A comma-separated list of slack users not to relay")
+	slackFormat  = flag.String("slackformat", defaultFormat, "The text/template of messages relayed to IRC from slack users other than -slacknick")
+	slackThreads = flag.Bool("slackthreads", true, "Whether to post IRC replies addressed to a slack user into the user's slack thread")
+	slackPage    = flag.Int("slackpagesize", slack.DefaultPageSize, "The number of items per page when listing slack users and channels")
+	slackBurst   = flag.Duration("slackcoalesce", 0, "How long after an IRC message later messages from its sender are posted to slack with it, if positive")
+	slackUpdate  = flag.Bool("slackcoalesceupdate", false, "Whether to post an IRC message at once and update the post with later messages if -slackcoalesce")
+)
+
+var (
+	stateFile = flag.String("statefile", "", "A file in which to keep the IDs of relayed messages and queued messages across restarts, if not empty")
+	idTTL     = flag.Duration("idttl", 7*24*time.Hour, "How long the IDs of relayed messages are kept in -statefile")
+	queueLen  = flag.Int("queuelen", bridge.DefaultQueueLen, "The maximum number of messages queued for each side of a bridge while it is unreachable")
+	queueAge  = flag.Duration("queueage", bridge.DefaultQueueAge, "How long a queued message may wait to be relayed")
+	bufferLen = flag.Int("buffer", bridge.DefaultBufferLen, "The number of messages from each side of a bridge that may await relay to the other")
+	overflow  = flag.String("overflow", "coalesce", "What to do with a message when -buffer messages await relay: block, drop-oldest, or coalesce")
 )
 
 func nick() string {
@@ -55,235 +93,177 @@ var icons = []string{
 
 func main() {
 	flag.Parse()
-
-	fromIRC := make(chan message)
-	ircClient := startIRC(fromIRC)
-	defer ircClient.Close()
-	log.Println("irc connected")
-
-	fromSlack := make(chan message)
-	slackClient, channelID := startSlack(fromSlack)
-	defer slackClient.Close()
-	log.Println("slack connected")
-
-	for {
-		select {
-		case msg := <-fromSlack:
-			if err := ircClient.Send(irc.PRIVMSG, *ircChannel, msg.text); err != nil {
-				log.Println("irc failed to send PRIVMSG:", err)
-			}
-		case msg := <-fromIRC:
-			server := strings.SplitN(*ircServer, ":", 2)[0]
-			var who, iconurl string
-			if msg.who == "" {
-				who = server
-				iconurl = "https://raw.githubusercontent.com/velour/relay/master/resource/servericon.png"
-			} else {
-				who = msg.who
-				var h int
-				for _, r := range who {
-					h = int(r) + 31*h
-				}
-				iconurl = icons[h%len(icons)]
-			}
-			if err := slackClient.PostMessage(who, iconurl, channelID, msg.text); err != nil {
-				log.Println("slack failed to post message:", err)
-			}
-		}
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := run(cfg); err != nil {
+		log.Fatalln(err)
 	}
 }
 
-type message struct {
-	who     string
-	channel string
-	text    string
-}
-
-func startSlack(ch chan<- message) (c *slack.Client, channelID string) {
-	c, err := slack.NewClient(*slackToken)
-	if err != nil {
-		log.Fatalln("slack failed to connect:", err)
+// run connects to the networks and workspaces of the config
+// and runs its bridges until they stop.
+// It returns an error if no bridge can be started.
+func run(cfg *config) error {
+	var files *fileServer
+	for _, b := range cfg.Bridges {
+		if b.Files == "host" {
+			files = startFileServer(cfg.Files)
+			defer files.close()
+			break
+		}
 	}
 
-	users, err := c.UsersList()
-	if err != nil {
-		log.Fatalln("slack failed to get users list:", err)
+	st := &state{cfg: cfg.State}
+	if cfg.State.Path != "" {
+		db, err := store.Open(cfg.State.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open state file: %v", err)
+		}
+		defer db.Close()
+		s, err := db.IDs(cfg.State.IDTTL.Duration)
+		if err != nil {
+			return fmt.Errorf("failed to open message IDs: %v", err)
+		}
+		go prune(s)
+		st.ids, st.queues = s, db
 	}
-	var userID string
-	for _, u := range users {
-		if u.Name == *slackNick {
-			userID = u.ID
-			break
+
+	puppets := newSupervisor()
+	defer puppets.close()
+	networks := make(map[string]*network)
+	for name, c := range cfg.IRC {
+		n, err := dialIRC(name, c)
+		if err != nil {
+			log.Printf("irc %s: %v", name, err)
+			continue
+		}
+		if c.Puppets {
+			n.puppets = puppets
 		}
+		defer n.close()
+		networks[name] = n
+		log.Printf("irc %s connected", name)
 	}
-	if userID == "" {
-		log.Fatalln("slack no user:", *slackNick)
+	workspaces := make(map[string]*workspace)
+	for name, c := range cfg.Slack {
+		w, err := dialSlack(name, c)
+		if err != nil {
+			log.Printf("slack %s: %v", name, err)
+			continue
+		}
+		defer w.client.Close()
+		workspaces[name] = w
+		log.Printf("slack %s connected", name)
 	}
 
-	var channels []slack.Channel
-	if strings.HasPrefix(*slackChannel, "#") {
-		channels, err = c.ChannelsList()
-	} else {
-		channels, err = c.GroupsList()
-	}
-	for _, c := range channels {
-		if c.Name == strings.TrimPrefix(*slackChannel, "#") {
-			channelID = c.ID
-			break
+	var wg sync.WaitGroup
+	started := 0
+	for _, bc := range cfg.Bridges {
+		n, w := networks[bc.IRC], workspaces[bc.Slack]
+		if n == nil || w == nil {
+			log.Printf("bridge %s not started: not connected", bc)
+			continue
+		}
+		var fs *fileServer
+		if bc.Files == "host" {
+			fs = files
 		}
+		wg.Add(1)
+		started++
+		go func(bc *bridgeConfig) {
+			defer wg.Done()
+			if err := runBridge(bc, n, w, fs, st); err != nil {
+				log.Printf("bridge %s failed: %v", bc, err)
+			}
+		}(bc)
 	}
-	if channelID == "" {
-		log.Fatalln("slack no channel:", *slackChannel)
+	if started == 0 {
+		return errors.New("no bridges started")
 	}
+	wg.Wait()
+	return nil
+}
 
-	go func() {
-		defer close(ch)
-		for {
-			event, err := c.Next()
-			if err != nil {
-				log.Fatalln("failed to read slack event:", err)
-				return
-			}
-			switch t, _ := event["type"].(string); t {
-			case "message":
-				if _, ok := event["subtype"]; ok {
-					break
-				}
-				if _, ok := event["reply_to"]; ok {
-					break
-				}
-				channel, _ := event["channel"].(string)
-				user, _ := event["user"].(string)
-				text, _ := event["text"].(string)
-				if channel != channelID || user != userID {
-					continue
-				}
-				text = unescape(text)
-				log.Printf("slack sending message\n%#v\n\n", event)
-				ch <- message{who: *slackNick, channel: *slackChannel, text: text}
-			case "presence_change",
-				"reconnect_url",
-				"user_typing":
-				// Silence noisy events.
-			default:
-				log.Printf("slack event:\n%#v\n\n", event)
-			}
+// pruneInterval is how often expired message IDs are deleted.
+const pruneInterval = time.Hour
+
+// prune deletes expired message IDs now and every pruneInterval.
+func prune(ids *store.IDs) {
+	for {
+		if n, err := ids.Prune(); err != nil {
+			log.Println("failed to prune message IDs:", err)
+		} else if n > 0 {
+			log.Printf("pruned %d message IDs", n)
 		}
+		time.Sleep(pruneInterval)
+	}
+}
+
+func startFileServer(cfg filesConfig) *fileServer {
+	files, err := newFileServer(cfg.URL, cfg.TTL.Duration)
+	if err != nil {
+		log.Fatalln("failed to create file server:", err)
+	}
+	go func() {
+		err := http.ListenAndServe(cfg.Listen, files)
+		files.close()
+		log.Fatalln("file server failed:", err)
 	}()
+	// Remove the files when the relay is stopped.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		log.Println("stopped by", <-sig)
+		files.close()
+		os.Exit(1)
+	}()
+	return files
+}
 
-	return c, channelID
+// A state is where bridges keep their state.
+type state struct {
+	cfg stateConfig
+	// ids and queues, if non-nil, keep the IDs of relayed messages
+	// and the messages queued for unreachable endpoints.
+	// Otherwise, they are kept in memory.
+	ids    bridge.IDStore
+	queues bridge.QueueStore
 }
 
-func unescape(str string) string {
-	return html.UnescapeString(str)
+// overflows are the bridge.Overflows of -overflow.
+var overflows = map[string]bridge.Overflow{
+	"block":       bridge.Block,
+	"drop-oldest": bridge.DropOldest,
+	"coalesce":    bridge.Coalesce,
 }
 
-func startIRC(ch chan<- message) *irc.Client {
-	var err error
-	var c *irc.Client
-	if *ircSSL {
-		c, err = irc.DialSSL(*ircServer, *ircNick, *ircFullName, *ircPassword, false)
-	} else {
-		c, err = irc.Dial(*ircServer, *ircNick, *ircFullName, *ircPassword)
-	}
+// runBridge relays messages between the bridge's IRC channel on network n
+// and its slack channel on workspace w until either connection is closed.
+// Messages for a side that is unreachable are queued until it reconnects.
+// Bridges share the connections to their IRC network and slack workspace
+// with the other bridges on them,
+// so an IRC channel may be bridged to many slack channels, and vice versa.
+// If files is non-nil, it re-hosts slack files.
+func runBridge(cfg *bridgeConfig, n *network, w *workspace, files *fileServer, st *state) error {
+	ircEP, err := newIRCEndpoint(n, cfg)
 	if err != nil {
-		log.Fatalln("irc failed to dial:", err)
+		return err
 	}
-
-	if err := c.Send(irc.JOIN, *ircChannel); err != nil {
-		log.Fatalln("irc failed to send JOIN:", err)
+	slackEP := newSlackEndpoint(w, cfg, files)
+	b := &bridge.Bridge{
+		Name:      cfg.String(),
+		A:         ircEP,
+		B:         slackEP,
+		Filter:    topicFilter(cfg.TopicSync, ircEP),
+		IDs:       st.ids,
+		Queues:    st.queues,
+		QueueLen:  st.cfg.QueueLen,
+		QueueAge:  st.cfg.QueueAge.Duration,
+		BufferLen: cfg.Buffer,
+		Overflow:  overflows[cfg.Overflow],
 	}
-
-	talkers := make(map[string]time.Time)
-
-	go func() {
-		defer close(ch)
-		for {
-			msg, err := c.Next()
-			if err != nil {
-				log.Fatalln("IRC read error:", err)
-				return
-			}
-			switch msg.Command {
-			case irc.JOIN:
-				if len(msg.Arguments) < 1 {
-					break
-				}
-				who := msg.Origin
-				when := talkers[who]
-				if who != *ircNick && time.Since(when) > time.Hour {
-					break
-				}
-				channel := msg.Arguments[0]
-				if channel != *ircChannel {
-					break
-				}
-				ch <- message{channel: channel, text: who + " joined"}
-
-			case irc.NICK:
-				if len(msg.Arguments) < 1 {
-					break
-				}
-				who := msg.Origin
-				when := talkers[who]
-				if who != *ircNick && time.Since(when) > time.Hour {
-					break
-				}
-				to := msg.Arguments[0]
-				talkers[to] = when
-				ch <- message{text: who + " is now " + to}
-
-			case irc.QUIT:
-				who := msg.Origin
-				when := talkers[who]
-				if who != *ircNick && time.Since(when) > time.Hour {
-					break
-				}
-				var why string
-				if len(msg.Arguments) > 0 {
-					why = msg.Arguments[0]
-				}
-				if why != "" {
-					ch <- message{text: who + " quit: " + why}
-				} else {
-					ch <- message{text: who + " quit"}
-				}
-
-			case irc.PART:
-				if len(msg.Arguments) < 1 {
-					break
-				}
-				who := msg.Origin
-				when := talkers[who]
-				if who != *ircNick && time.Since(when) > time.Hour {
-					break
-				}
-				channel := msg.Arguments[0]
-				if channel != *ircChannel {
-					break
-				}
-				ch <- message{channel: channel, text: who + " parted"}
-
-			case irc.PRIVMSG:
-				if len(msg.Arguments) < 2 {
-					break
-				}
-				who := msg.Origin
-				talkers[who] = time.Now()
-				channel := msg.Arguments[0]
-				text := msg.Arguments[1]
-				if channel != *ircChannel {
-					break
-				}
-				if who == *ircNick {
-					break
-				}
-				ch <- message{who: who, channel: channel, text: text}
-			default:
-				log.Printf("irc message:\n%#v\n\n", msg)
-			}
-		}
-	}()
-
-	return c
+	log.Printf("bridge %s starting", cfg)
+	return b.Run()
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velour/relay/bridge"
+	"github.com/velour/relay/slack"
+	"github.com/velour/relay/slack/slacktest"
+)
+
+func TestStartSlack(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "U1", Name: "alice"})
+	s.AddUser(slack.User{ID: "U2", Name: "bob"})
+	s.AddConversation(slack.Conversation{ID: "C1", Name: "general", IsChannel: true})
+	sc := flagSlackConfig()
+	sc.API = s.URL
+	sc.Nick = "alice"
+	w, err := dialSlack("test", sc)
+	if err != nil {
+		t.Fatalf("dialSlack failed: %v", err)
+	}
+	c := w.client
+	defer c.Close()
+
+	ep := newSlackEndpoint(w, flagBridgeConfig(), nil)
+	ch := ep.Events()
+	channelID, err := w.join("general", ep)
+	if err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	if channelID != "C1" {
+		t.Errorf("join channelID=%q, want C1", channelID)
+	}
+
+	s.SendMessage("C1", "U2", "not relayed")
+	s.SendMessage("C1", "U1", "fish &amp; chips")
+	select {
+	case e := <-ch:
+		if e.Kind != bridge.Message || !e.Self || e.ID == "" || e.Text != "fish & chips" {
+			t.Errorf("relayed %+v, want own message %q", e, "fish & chips")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a relayed message")
+	}
+
+	parent := s.SendMessage("C1", "U2", "does anyone know how to fix the frobnicator?")
+	s.SendReply("C1", "U1", parent, "turn it off and on")
+	select {
+	case e := <-ch:
+		want := "[re bob: 'does anyone know how to fix…'] turn it off and on"
+		if e.Body() != want {
+			t.Errorf("relayed %q, want %q", e.Body(), want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a relayed message")
+	}
+
+	s.SendEvent(map[string]interface{}{
+		"type":             "message",
+		"subtype":          "message_changed",
+		"channel":          "C1",
+		"message":          map[string]interface{}{"user": "U1", "ts": "1.5", "text": "turn it off and on again"},
+		"previous_message": map[string]interface{}{"user": "U1", "text": "turn it off and on"},
+	})
+	select {
+	case e := <-ch:
+		if want := "turn it off and on again"; e.Kind != bridge.Edit || e.Target.ID != "1.5" || e.From != "alice" || e.Text != want {
+			t.Errorf("relayed %+v, want alice's edit of 1.5 to %q", e, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a relayed message")
+	}
+
+	// The relay's own posts are not relayed back.
+	if _, err := c.Post(slack.PostParams{Channel: "C1", Username: "carol", Text: "from IRC"}); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	s.SendEvent(map[string]interface{}{
+		"type":        "message",
+		"subtype":     "bot_message",
+		"channel":     "C1",
+		"bot_id":      "BCI",
+		"username":    "ci",
+		"attachments": []map[string]interface{}{{"title": "build failed", "text": "3 tests failed"}},
+	})
+	select {
+	case e := <-ch:
+		if want := "build failed\n3 tests failed"; !e.Bot || e.From != "ci" || e.UserID != "BCI" || e.Text != want {
+			t.Errorf("relayed %+v, want ci bot's %q", e, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a relayed message")
+	}
+
+	s.SendEvent(map[string]interface{}{
+		"type":    "message",
+		"subtype": "me_message",
+		"channel": "C1",
+		"user":    "U1",
+		"text":    "waves",
+	})
+	select {
+	case e := <-ch:
+		if e.Kind != bridge.Action || e.Text != "waves" {
+			t.Errorf("relayed %+v, want action %q", e, "waves")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a relayed message")
+	}
+}
+
+func TestSharedMode(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "U1", Name: "alice"})
+	s.AddUser(slack.User{ID: "U2", Name: "bob", Profile: slack.Profile{DisplayName: "Bobby"}})
+	s.AddUser(slack.User{ID: "U3", Name: "carol"})
+	s.AddConversation(slack.Conversation{ID: "C1", Name: "general", IsChannel: true})
+	sc := flagSlackConfig()
+	sc.API = s.URL
+	sc.Nick = ""
+	w, err := dialSlack("test", sc)
+	if err != nil {
+		t.Fatalf("dialSlack failed: %v", err)
+	}
+	defer w.client.Close()
+	// dave joins after the directory is loaded.
+	s.AddUser(slack.User{ID: "U4", Name: "dave"})
+
+	cfg := flagBridgeConfig()
+	cfg.Shared = true
+	cfg.Deny = []string{"carol"}
+	cfg.Format = "{{.Name}} ({{.User}}): {{.Text}}"
+	ep := newSlackEndpoint(w, cfg, nil)
+	if _, err := w.join("general", ep); err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	ircEP, err := newIRCEndpoint(nil, cfg)
+	if err != nil {
+		t.Fatalf("newIRCEndpoint failed: %v", err)
+	}
+
+	s.SendMessage("C1", "U3", "denied")
+	s.SendMessage("C1", "U2", "hi\nthere")
+	s.SendMessage("C1", "U4", "hello")
+	for _, want := range [][]string{{"Bobby (bob): hi", "Bobby (bob): there"}, {"dave (dave): hello"}} {
+		select {
+		case e := <-ep.Events():
+			if got := ircEP.lines(e); !reflect.DeepEqual(got, want) {
+				t.Errorf("relayed %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a relayed message")
+		}
+	}
+}
+
+func TestWorkspaceFanOut(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser(slack.User{ID: "U1", Name: "alice"})
+	s.AddConversation(slack.Conversation{ID: "C1", Name: "general", IsChannel: true})
+	s.AddConversation(slack.Conversation{ID: "C2", Name: "random", IsChannel: true})
+	sc := flagSlackConfig()
+	sc.API = s.URL
+	sc.Nick = "alice"
+	w, err := dialSlack("test", sc)
+	if err != nil {
+		t.Fatalf("dialSlack failed: %v", err)
+	}
+	defer w.client.Close()
+
+	// Two bridges of general and one of random share the connection.
+	var chs []<-chan bridge.Event
+	for _, name := range []string{"general", "general", "random"} {
+		ep := newSlackEndpoint(w, flagBridgeConfig(), nil)
+		if _, err := w.join(name, ep); err != nil {
+			t.Fatalf("join(%q) failed: %v", name, err)
+		}
+		chs = append(chs, ep.Events())
+	}
+
+	s.SendMessage("C1", "U1", "hello")
+	for i, ch := range chs[:2] {
+		select {
+		case e := <-ch:
+			if e.Text != "hello" {
+				t.Errorf("bridge %d relayed %q, want hello", i, e.Text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("bridge %d timed out waiting for a relayed message", i)
+		}
+	}
+	select {
+	case e := <-chs[2]:
+		t.Errorf("random bridge relayed %q from general", e.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestThreadsRoute(t *testing.T) {
+	threads := newThreads()
+	threads.add("1.1", "", "bob", "question?")
+	threads.add("1.2", "1.1", "Alice", "answer", "alice", "Alice")
+	tests := []struct {
+		text, want string
+	}{
+		{"alice: thanks", "1.1"},
+		{"Alice, thanks", "1.1"},
+		{"@alice: thanks", "1.1"},
+		{"bob: thanks", ""},
+		{"alice:thanks", ""},
+		{"thanks alice", ""},
+	}
+	for _, test := range tests {
+		if got := threads.route(test.text); got != test.want {
+			t.Errorf("route(%q)=%q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestIRCEndpointReceive(t *testing.T) {
+	ep, err := newIRCEndpoint(nil, flagBridgeConfig())
+	if err != nil {
+		t.Fatalf("newIRCEndpoint failed: %v", err)
+	}
+	ep.events = make(chan bridge.Event, 10)
+	say := func(who, text string) bridge.Event {
+		ep.receive(bridge.Event{Kind: bridge.Message, From: who, Text: text})
+		return <-ep.events
+	}
+
+	first := say("alice", "teh frobnicator")
+	if first.Kind != bridge.Message || first.ID == "" {
+		t.Fatalf("got %+v, want a message with an ID", first)
+	}
+	if e := say("bob", "s/teh/the/"); e.Kind != bridge.Message {
+		t.Errorf("bob's correction without a message is %v, want message", e.Kind)
+	}
+	if e := say("alice", "s/teh/the/"); e.Kind != bridge.Edit || e.Target.ID != first.ID || e.Text != "the frobnicator" {
+		t.Errorf("got %+v, want edit of %s to %q", e, first.ID, "the frobnicator")
+	}
+	if e := say("alice", "s/the frobnicator//"); e.Kind != bridge.Delete || e.Target.ID != first.ID {
+		t.Errorf("got %+v, want delete of %s", e, first.ID)
+	}
+}
+
+func TestIRCEndpointLines(t *testing.T) {
+	ep, err := newIRCEndpoint(nil, flagBridgeConfig())
+	if err != nil {
+		t.Fatalf("newIRCEndpoint failed: %v", err)
+	}
+	tests := []struct {
+		event bridge.Event
+		want  []string
+	}{
+		{bridge.Event{Kind: bridge.Message, From: "bob", Text: "hi\nthere"}, []string{"<bob> hi", "<bob> there"}},
+		{bridge.Event{Kind: bridge.Message, From: "alice", Self: true, Text: "hi", ReplyTo: &bridge.Ref{From: "bob", Text: "hello"}}, []string{"[re bob: 'hello'] hi"}},
+		{bridge.Event{Kind: bridge.Action, From: "alice", Self: true, Text: "waves"}, []string{"\x01ACTION waves\x01"}},
+		{bridge.Event{Kind: bridge.Action, From: "bob", Text: "waves"}, []string{"* bob waves"}},
+		{bridge.Event{Kind: bridge.Quit, From: "bob", Text: "bye"}, []string{"bob quit: bye"}},
+		{
+			bridge.Event{Kind: bridge.Message, From: "bob", Attachments: []bridge.Attachment{{Name: "a.png", Size: 2048, URL: "https://x/a.png"}}},
+			[]string{"bob shared a.png (2.0 KB): https://x/a.png"},
+		},
+		{
+			bridge.Event{Kind: bridge.Message, From: "alice", Self: true, Text: strings.Repeat("word ", 100)},
+			[]string{strings.Repeat("word ", 79) + "word", strings.Repeat("word ", 20)},
+		},
+	}
+	for _, test := range tests {
+		if got := ep.lines(test.event); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("lines(%+v)=%q, want %q", test.event, got, test.want)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line string
+		n    int
+		want []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"split at  spaces", 8, []string{"split", "at", "spaces"}},
+		{"unbrokenline", 5, []string{"unbro", "kenli", "ne"}},
+		{"zoë zoë", 3, []string{"zo", "ë", "zo", "ë"}},
+	}
+	for _, test := range tests {
+		if got := splitLine(test.line, test.n); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitLine(%q, %d)=%q, want %q", test.line, test.n, got, test.want)
+		}
+	}
+}
+
+func TestIRCEndpointSend(t *testing.T) {
+	addr, lines := fakeIRCServer(t)
+	cfg := &ircConfig{Server: addr, Nick: "relay"}
+	c, err := dialServer(cfg, cfg.Nick, "")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	n := &network{name: "test", cfg: cfg, client: c, routes: make(map[string][]*ircEndpoint)}
+	bc := flagBridgeConfig()
+	bc.IRCChannel = "#go"
+	ep, err := newIRCEndpoint(n, bc)
+	if err != nil {
+		t.Fatalf("newIRCEndpoint failed: %v", err)
+	}
+
+	long := strings.Repeat("x", 600)
+	if _, err := ep.Send(bridge.Event{Kind: bridge.Message, Self: true, Text: long}); err != nil {
+		t.Fatalf("Send(a long message) failed: %v", err)
+	}
+	var got string
+	for len(got) < len(long) {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, "PRIVMSG #go :") {
+				continue
+			}
+			got += strings.TrimPrefix(line, "PRIVMSG #go :")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the message, got %q", got)
+		}
+	}
+	if got != long {
+		t.Errorf("sent %q, want %q", got, long)
+	}
+
+	c.Close()
+	_, err = ep.Send(bridge.Event{Kind: bridge.Message, Self: true, Text: "hi"})
+	if _, ok := err.(bridge.UnavailableError); !ok {
+		t.Errorf("Send on a closed connection=%v, want a bridge.UnavailableError", err)
+	}
+}
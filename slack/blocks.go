@@ -0,0 +1,493 @@
+package slack
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// A Block is a Block Kit layout block.
+// The block types are Section, Context, Divider, Image, RichText,
+// and UnknownBlock for any other type.
+type Block interface {
+	// BlockType returns the value of the block's type field.
+	BlockType() string
+}
+
+// Blocks is a list of blocks that can be unmarshaled from JSON.
+type Blocks []Block
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (bs *Blocks) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return err
+	}
+	*bs = make(Blocks, 0, len(raws))
+	for _, raw := range raws {
+		var t struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return err
+		}
+		var b Block
+		var err error
+		switch t.Type {
+		case "section":
+			var sec Section
+			err = json.Unmarshal(raw, (*sectionFields)(&sec))
+			b = sec
+		case "context":
+			var ctx Context
+			err = json.Unmarshal(raw, &ctx)
+			b = ctx
+		case "divider":
+			var div Divider
+			err = json.Unmarshal(raw, (*dividerFields)(&div))
+			b = div
+		case "image":
+			var img Image
+			err = json.Unmarshal(raw, (*imageFields)(&img))
+			b = img
+		case "rich_text":
+			var rt RichText
+			err = json.Unmarshal(raw, (*richTextFields)(&rt))
+			b = rt
+		default:
+			b = UnknownBlock{Type: t.Type, JSON: raw}
+		}
+		if err != nil {
+			return err
+		}
+		*bs = append(*bs, b)
+	}
+	return nil
+}
+
+// Text returns the readable text of the blocks, one line per block.
+// Mentions and links in rich text are written in mrkdwn.
+func (bs Blocks) Text() string {
+	var lines []string
+	for _, b := range bs {
+		if t := blockText(b); t != "" {
+			lines = append(lines, t)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func blockText(b Block) string {
+	switch b := b.(type) {
+	case Section:
+		var lines []string
+		if b.Text != nil && b.Text.Text != "" {
+			lines = append(lines, b.Text.Text)
+		}
+		for _, f := range b.Fields {
+			if f != nil && f.Text != "" {
+				lines = append(lines, f.Text)
+			}
+		}
+		return strings.Join(lines, "\n")
+	case Context:
+		var words []string
+		for _, e := range b.Elements {
+			if t, ok := e.(*Text); ok && t.Text != "" {
+				words = append(words, t.Text)
+			}
+		}
+		return strings.Join(words, " ")
+	case Image:
+		if b.Title != nil && b.Title.Text != "" {
+			return b.Title.Text
+		}
+		return b.AltText
+	case RichText:
+		var lines []string
+		for _, sec := range b.Elements {
+			var line strings.Builder
+			for _, e := range sec.Elements {
+				if e.Type != richTextSectionType {
+					line.WriteString(e.mrkdwn())
+					continue
+				}
+				// A list item.
+				lines = append(lines, "• "+e.mrkdwn())
+			}
+			if line.Len() > 0 {
+				lines = append(lines, line.String())
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
+
+// An UnknownBlock is a block of a type not otherwise supported.
+type UnknownBlock struct {
+	Type string
+	// JSON is the JSON encoding of the block.
+	JSON json.RawMessage
+}
+
+// BlockType returns the type of the block.
+func (b UnknownBlock) BlockType() string { return b.Type }
+
+// MarshalJSON implements json.Marshaler.
+func (b UnknownBlock) MarshalJSON() ([]byte, error) {
+	return b.JSON, nil
+}
+
+// A ContextElement is an element of a Context block:
+// a *Text or an ImageElement.
+type ContextElement interface {
+	contextElement()
+}
+
+// Text object types.
+const (
+	PlainTextType = "plain_text"
+	MarkdownType  = "mrkdwn"
+)
+
+// A Text is a Block Kit text object.
+type Text struct {
+	// Type is PlainTextType or MarkdownType.
+	Type string `json:"type"`
+	Text string `json:"text"`
+	// Emoji is whether emoji shortcodes are rendered in plain text.
+	Emoji bool `json:"emoji,omitempty"`
+	// Verbatim is whether markdown text is shown without
+	// linkifying URLs and mentions.
+	Verbatim bool `json:"verbatim,omitempty"`
+}
+
+func (*Text) contextElement() {}
+
+// PlainText returns a plain text object.
+func PlainText(text string) *Text {
+	return &Text{Type: PlainTextType, Text: text}
+}
+
+// Markdown returns a mrkdwn text object.
+func Markdown(text string) *Text {
+	return &Text{Type: MarkdownType, Text: text}
+}
+
+// An ImageElement is an image in a Context block.
+type ImageElement struct {
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+func (ImageElement) contextElement() {}
+
+// MarshalJSON implements json.Marshaler.
+func (e ImageElement) MarshalJSON() ([]byte, error) {
+	type elem ImageElement
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		elem
+	}{"image", elem(e)})
+}
+
+// The fields of each block type,
+// without the MarshalJSON method.
+type (
+	sectionFields  Section
+	dividerFields  Divider
+	imageFields    Image
+	richTextFields RichText
+)
+
+// A Section is a block of text, optionally with fields
+// shown in two columns.
+type Section struct {
+	BlockID string  `json:"block_id,omitempty"`
+	Text    *Text   `json:"text,omitempty"`
+	Fields  []*Text `json:"fields,omitempty"`
+}
+
+// BlockType returns "section".
+func (Section) BlockType() string { return "section" }
+
+// MarshalJSON implements json.Marshaler.
+func (b Section) MarshalJSON() ([]byte, error) {
+	return marshalBlock(b, sectionFields(b))
+}
+
+// A Context is a block of small, grey text and images.
+type Context struct {
+	BlockID  string           `json:"block_id,omitempty"`
+	Elements []ContextElement `json:"elements"`
+}
+
+// NewContext returns a Context block with the given elements.
+func NewContext(elems ...ContextElement) Context {
+	return Context{Elements: elems}
+}
+
+// BlockType returns "context".
+func (Context) BlockType() string { return "context" }
+
+// MarshalJSON implements json.Marshaler.
+func (b Context) MarshalJSON() ([]byte, error) {
+	type block Context
+	return marshalBlock(b, block(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+// Elements of unsupported types are dropped.
+func (b *Context) UnmarshalJSON(data []byte) error {
+	var ctx struct {
+		BlockID  string            `json:"block_id"`
+		Elements []json.RawMessage `json:"elements"`
+	}
+	if err := json.Unmarshal(data, &ctx); err != nil {
+		return err
+	}
+	*b = Context{BlockID: ctx.BlockID}
+	for _, raw := range ctx.Elements {
+		var t struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return err
+		}
+		switch t.Type {
+		case PlainTextType, MarkdownType:
+			var text Text
+			if err := json.Unmarshal(raw, &text); err != nil {
+				return err
+			}
+			b.Elements = append(b.Elements, &text)
+		case "image":
+			type elem ImageElement
+			var img elem
+			if err := json.Unmarshal(raw, &img); err != nil {
+				return err
+			}
+			b.Elements = append(b.Elements, ImageElement(img))
+		}
+	}
+	return nil
+}
+
+// A Divider is a horizontal line between blocks.
+type Divider struct {
+	BlockID string `json:"block_id,omitempty"`
+}
+
+// BlockType returns "divider".
+func (Divider) BlockType() string { return "divider" }
+
+// MarshalJSON implements json.Marshaler.
+func (b Divider) MarshalJSON() ([]byte, error) {
+	return marshalBlock(b, dividerFields(b))
+}
+
+// An Image is a block containing an image.
+type Image struct {
+	BlockID  string `json:"block_id,omitempty"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+	// Title is an optional plain text title.
+	Title *Text `json:"title,omitempty"`
+}
+
+// BlockType returns "image".
+func (Image) BlockType() string { return "image" }
+
+// MarshalJSON implements json.Marshaler.
+func (b Image) MarshalJSON() ([]byte, error) {
+	return marshalBlock(b, imageFields(b))
+}
+
+// A RichText is a block of formatted text.
+type RichText struct {
+	BlockID  string            `json:"block_id,omitempty"`
+	Elements []RichTextSection `json:"elements"`
+}
+
+// BlockType returns "rich_text".
+func (RichText) BlockType() string { return "rich_text" }
+
+// MarshalJSON implements json.Marshaler.
+func (b RichText) MarshalJSON() ([]byte, error) {
+	return marshalBlock(b, richTextFields(b))
+}
+
+// A RichTextSection is a paragraph of a RichText block.
+type RichTextSection struct {
+	Elements []RichTextElement `json:"elements"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s RichTextSection) MarshalJSON() ([]byte, error) {
+	type section RichTextSection
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		section
+	}{richTextSectionType, section(s)})
+}
+
+// Rich text element types.
+const (
+	RichTextText      = "text"
+	RichTextLink      = "link"
+	RichTextUser      = "user"
+	RichTextUserGroup = "usergroup"
+	RichTextChannel   = "channel"
+	RichTextBroadcast = "broadcast"
+	RichTextEmoji     = "emoji"
+)
+
+// A RichTextElement is a run of text, a link, a mention, or an emoji
+// in a RichTextSection.
+type RichTextElement struct {
+	// Type is one of RichTextText, RichTextLink, RichTextUser,
+	// RichTextUserGroup, RichTextChannel, RichTextBroadcast, or RichTextEmoji.
+	Type string `json:"type"`
+	// Text is the text of a text or link element.
+	Text string `json:"text,omitempty"`
+	// URL is the URL of a link element.
+	URL string `json:"url,omitempty"`
+	// UserID is the user of a user element.
+	UserID string `json:"user_id,omitempty"`
+	// UserGroupID is the user group of a usergroup element.
+	UserGroupID string `json:"usergroup_id,omitempty"`
+	// ChannelID is the channel of a channel element.
+	ChannelID string `json:"channel_id,omitempty"`
+	// Range is who a broadcast element mentions:
+	// here, channel, or everyone.
+	Range string `json:"range,omitempty"`
+	// Name is the shortcode of an emoji element, without colons.
+	Name  string         `json:"name,omitempty"`
+	Style *RichTextStyle `json:"style,omitempty"`
+	// Elements are the elements of a list item.
+	// They are only set by unmarshaling a rich_text_list.
+	Elements []RichTextElement `json:"elements,omitempty"`
+}
+
+// richTextSectionType is the type of a RichTextSection,
+// and of an item of a rich_text_list.
+const richTextSectionType = "rich_text_section"
+
+// mrkdwn returns the element written in mrkdwn.
+func (e RichTextElement) mrkdwn() string {
+	switch e.Type {
+	case RichTextText:
+		return e.Text
+	case RichTextLink:
+		if e.Text == "" {
+			return "<" + e.URL + ">"
+		}
+		return "<" + e.URL + "|" + e.Text + ">"
+	case RichTextUser:
+		return "<@" + e.UserID + ">"
+	case RichTextUserGroup:
+		return "<!subteam^" + e.UserGroupID + ">"
+	case RichTextChannel:
+		return "<#" + e.ChannelID + ">"
+	case RichTextBroadcast:
+		return "<!" + e.Range + ">"
+	case RichTextEmoji:
+		return ":" + e.Name + ":"
+	case richTextSectionType:
+		var s strings.Builder
+		for _, e := range e.Elements {
+			s.WriteString(e.mrkdwn())
+		}
+		return s.String()
+	}
+	return ""
+}
+
+// A RichTextStyle is the style of a RichTextElement.
+type RichTextStyle struct {
+	Bold   bool `json:"bold,omitempty"`
+	Italic bool `json:"italic,omitempty"`
+	Strike bool `json:"strike,omitempty"`
+	Code   bool `json:"code,omitempty"`
+}
+
+// marshalBlock marshals the fields of a block,
+// which is b converted to a type without a MarshalJSON method,
+// with the type field set to the type of b.
+func marshalBlock(b Block, block interface{}) ([]byte, error) {
+	fields, err := json.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := json.Marshal(b.BlockType())
+	if err != nil {
+		return nil, err
+	}
+	// fields is a JSON object, at least "{}".
+	data := append([]byte(`{"type":`), typ...)
+	if len(fields) > 2 {
+		data = append(data, ',')
+	}
+	return append(data, fields[1:]...), nil
+}
+
+// An Attachment is a legacy secondary attachment of a message.
+type Attachment struct {
+	// Fallback is the plain text summary of the attachment.
+	Fallback   string            `json:"fallback,omitempty"`
+	Color      string            `json:"color,omitempty"`
+	Pretext    string            `json:"pretext,omitempty"`
+	AuthorName string            `json:"author_name,omitempty"`
+	AuthorLink string            `json:"author_link,omitempty"`
+	AuthorIcon string            `json:"author_icon,omitempty"`
+	Title      string            `json:"title,omitempty"`
+	TitleLink  string            `json:"title_link,omitempty"`
+	Text       string            `json:"text,omitempty"`
+	Fields     []AttachmentField `json:"fields,omitempty"`
+	ImageURL   string            `json:"image_url,omitempty"`
+	ThumbURL   string            `json:"thumb_url,omitempty"`
+	Footer     string            `json:"footer,omitempty"`
+	FooterIcon string            `json:"footer_icon,omitempty"`
+	Blocks     Blocks            `json:"blocks,omitempty"`
+}
+
+// FallbackText returns the readable text of the attachment.
+// This is its Fallback if set, and otherwise
+// its pretext, title, text, fields, blocks, and footer, one per line.
+func (a Attachment) FallbackText() string {
+	if a.Fallback != "" {
+		return a.Fallback
+	}
+	var lines []string
+	add := func(s string) {
+		if s != "" {
+			lines = append(lines, s)
+		}
+	}
+	add(a.Pretext)
+	switch {
+	case a.Title != "" && a.TitleLink != "":
+		add("<" + a.TitleLink + "|" + a.Title + ">")
+	default:
+		add(a.Title)
+	}
+	add(a.Text)
+	for _, f := range a.Fields {
+		switch {
+		case f.Title == "":
+			add(f.Value)
+		default:
+			add(f.Title + ": " + f.Value)
+		}
+	}
+	add(a.Blocks.Text())
+	add(a.Footer)
+	return strings.Join(lines, "\n")
+}
+
+// An AttachmentField is a field of an Attachment.
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
@@ -0,0 +1,118 @@
+package slack
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMarshalBlocks(t *testing.T) {
+	tests := []struct {
+		block Block
+		want  string
+	}{
+		{
+			block: Section{Text: Markdown("*alice* said hi")},
+			want:  `{"type":"section","text":{"type":"mrkdwn","text":"*alice* said hi"}}`,
+		},
+		{
+			block: Section{BlockID: "b1", Fields: []*Text{PlainText("a"), PlainText("b")}},
+			want:  `{"type":"section","block_id":"b1","fields":[{"type":"plain_text","text":"a"},{"type":"plain_text","text":"b"}]}`,
+		},
+		{
+			block: NewContext(ImageElement{ImageURL: "http://x/i.png", AltText: "icon"}, Markdown("*bob* joined")),
+			want:  `{"type":"context","elements":[{"type":"image","image_url":"http://x/i.png","alt_text":"icon"},{"type":"mrkdwn","text":"*bob* joined"}]}`,
+		},
+		{
+			block: Divider{},
+			want:  `{"type":"divider"}`,
+		},
+		{
+			block: Image{ImageURL: "http://x/i.png", AltText: "pic", Title: PlainText("Pic")},
+			want:  `{"type":"image","image_url":"http://x/i.png","alt_text":"pic","title":{"type":"plain_text","text":"Pic"}}`,
+		},
+		{
+			block: RichText{Elements: []RichTextSection{{Elements: []RichTextElement{
+				{Type: RichTextText, Text: "hi ", Style: &RichTextStyle{Bold: true}},
+				{Type: RichTextUser, UserID: "U1"},
+				{Type: RichTextEmoji, Name: "wave"},
+			}}}},
+			want: `{"type":"rich_text","elements":[{"type":"rich_text_section","elements":[` +
+				`{"type":"text","text":"hi ","style":{"bold":true}},` +
+				`{"type":"user","user_id":"U1"},` +
+				`{"type":"emoji","name":"wave"}]}]}`,
+		},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(test.block)
+		if err != nil {
+			t.Errorf("json.Marshal(%#v) failed: %v", test.block, err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("json.Marshal(%#v)=\n%s\nwant\n%s", test.block, data, test.want)
+		}
+	}
+}
+
+func TestUnmarshalBlocks(t *testing.T) {
+	const data = `[
+		{"type":"section","text":{"type":"mrkdwn","text":"*Deploy* finished"},"fields":[{"type":"mrkdwn","text":"env: prod"}]},
+		{"type":"divider"},
+		{"type":"context","elements":[{"type":"image","image_url":"http://x/i.png","alt_text":"icon"},{"type":"plain_text","text":"by ci"}]},
+		{"type":"actions","elements":[]},
+		{"type":"rich_text","elements":[
+			{"type":"rich_text_section","elements":[{"type":"text","text":"ping "},{"type":"user","user_id":"U1"},{"type":"text","text":" "},{"type":"broadcast","range":"here"},{"type":"text","text":" "},{"type":"usergroup","usergroup_id":"S1"}]},
+			{"type":"rich_text_list","elements":[{"type":"rich_text_section","elements":[{"type":"text","text":"one"}]}]}
+		]}
+	]`
+	var bs Blocks
+	if err := json.Unmarshal([]byte(data), &bs); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	var types []string
+	for _, b := range bs {
+		types = append(types, b.BlockType())
+	}
+	if got, want := strings.Join(types, " "), "section divider context actions rich_text"; got != want {
+		t.Errorf("block types=%q, want %q", got, want)
+	}
+	want := "*Deploy* finished\nenv: prod\nby ci\nping <@U1> <!here> <!subteam^S1>\n• one"
+	if got := bs.Text(); got != want {
+		t.Errorf("Text()=%q, want %q", got, want)
+	}
+	if got, err := json.Marshal(bs[3]); err != nil || string(got) != `{"type":"actions","elements":[]}` {
+		t.Errorf("Marshal(unknown block)=%s, %v", got, err)
+	}
+}
+
+func TestMessageFallbackText(t *testing.T) {
+	tests := []struct {
+		msg  Message
+		want string
+	}{
+		{Message{Text: "hi"}, "hi"},
+		{Message{Blocks: Blocks{Section{Text: Markdown("from blocks")}}}, "from blocks"},
+		{
+			Message{Text: "hi", Blocks: Blocks{Section{Text: Markdown("from blocks")}}},
+			"hi",
+		},
+		{
+			Message{Attachments: []Attachment{{Fallback: "summary", Text: "long text"}}},
+			"summary",
+		},
+		{
+			Message{Text: "alert", Attachments: []Attachment{{
+				Title:     "CPU high",
+				TitleLink: "http://x/",
+				Fields:    []AttachmentField{{Title: "host", Value: "db1"}},
+			}}},
+			"alert\n<http://x/|CPU high>\nhost: db1",
+		},
+	}
+	for _, test := range tests {
+		if got := test.msg.FallbackText(); got != test.want {
+			t.Errorf("%+v.FallbackText()=%q, want %q", test.msg, got, test.want)
+		}
+	}
+}
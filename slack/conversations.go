@@ -0,0 +1,298 @@
+package slack
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conversation types, as accepted by ConversationsList.
+const (
+	PublicChannel  = "public_channel"
+	PrivateChannel = "private_channel"
+	MPIM           = "mpim"
+	IM             = "im"
+)
+
+// A Conversation object describes a slack conversation:
+// a public or private channel, a multi-person direct message,
+// or a direct message.
+type Conversation struct {
+	ID string `json:"id"`
+	// Name is the name of the channel without a leading #.
+	// It is empty for direct messages.
+	Name       string `json:"name"`
+	IsChannel  bool   `json:"is_channel"`
+	IsGroup    bool   `json:"is_group"`
+	IsIM       bool   `json:"is_im"`
+	IsMPIM     bool   `json:"is_mpim"`
+	IsPrivate  bool   `json:"is_private"`
+	IsArchived bool   `json:"is_archived"`
+	IsMember   bool   `json:"is_member"`
+	// User is the ID of the other user of a direct message.
+	User       string `json:"user"`
+	Topic      Topic  `json:"topic"`
+	Purpose    Topic  `json:"purpose"`
+	NumMembers int    `json:"num_members"`
+}
+
+// A Topic is the topic or purpose of a conversation.
+type Topic struct {
+	Value   string `json:"value"`
+	Creator string `json:"creator"`
+	LastSet int64  `json:"last_set"`
+}
+
+// A Message object describes a message in a conversation.
+type Message struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	User     string `json:"user,omitempty"`
+	BotID    string `json:"bot_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	// ThreadTS is the timestamp of the parent message of a thread.
+	// It is set on both the parent and its replies.
+	ThreadTS     string `json:"thread_ts,omitempty"`
+	ParentUserID string `json:"parent_user_id,omitempty"`
+	ReplyCount   int    `json:"reply_count,omitempty"`
+	Files        []File `json:"files,omitempty"`
+	// Attachments and Blocks hold the content of messages,
+	// often from bots, that have little or no Text.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Blocks      Blocks       `json:"blocks,omitempty"`
+}
+
+// FallbackText returns the readable text of the message:
+// its Text, or the text of its Blocks if Text is empty,
+// followed by the fallback text of each attachment, one per line.
+func (m Message) FallbackText() string {
+	var lines []string
+	switch {
+	case m.Text != "":
+		lines = append(lines, m.Text)
+	case len(m.Blocks) > 0:
+		if t := m.Blocks.Text(); t != "" {
+			lines = append(lines, t)
+		}
+	}
+	for _, a := range m.Attachments {
+		if t := a.FallbackText(); t != "" {
+			lines = append(lines, t)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IsReply returns whether the message is a reply in a thread.
+func (m Message) IsReply() bool {
+	return m.ThreadTS != "" && m.ThreadTS != m.TS
+}
+
+// Time returns the time of the message, given by its timestamp,
+// or the zero time if the timestamp is malformed.
+func (m Message) Time() time.Time {
+	f, err := strconv.ParseFloat(m.TS, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// ConversationsList returns a list of all conversations of the given types.
+// If no types are given, only public channels are listed.
+func (c *Client) ConversationsList(types ...string) ([]Conversation, error) {
+	var convs []Conversation
+	it := c.Conversations(types...)
+	for {
+		conv, err := it.Next()
+		if err == io.EOF {
+			return convs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		convs = append(convs, conv)
+	}
+}
+
+// Conversations returns an iterator over all conversations of the given types.
+// If no types are given, only public channels are listed.
+func (c *Client) Conversations(types ...string) *ConversationIterator {
+	var args []string
+	if len(types) > 0 {
+		args = append(args, "types="+strings.Join(types, ","))
+	}
+	return &ConversationIterator{pager: pager{c: c, method: "conversations.list", args: args}}
+}
+
+// A ConversationIterator iterates over the pages of a conversations.list response.
+type ConversationIterator struct {
+	pager
+	convs []Conversation
+}
+
+// Next returns the next conversation.
+// It returns io.EOF after the last conversation.
+func (it *ConversationIterator) Next() (Conversation, error) {
+	for len(it.convs) == 0 {
+		var resp struct {
+			Response
+			Channels []Conversation `json:"channels"`
+		}
+		if err := it.page(&resp, &resp.Response); err != nil {
+			return Conversation{}, err
+		}
+		it.convs = resp.Channels
+	}
+	conv := it.convs[0]
+	it.convs = it.convs[1:]
+	return conv, nil
+}
+
+// ConversationsInfo returns the conversation with the given ID.
+func (c *Client) ConversationsInfo(id string) (Conversation, error) {
+	var resp struct {
+		Response
+		Channel Conversation `json:"channel"`
+	}
+	if err := c.do(&resp, "conversations.info", "channel="+id); err != nil {
+		return Conversation{}, err
+	}
+	if !resp.OK {
+		return Conversation{}, ResponseError{resp.Response}
+	}
+	return resp.Channel, nil
+}
+
+// ConversationsJoin joins the conversation with the given ID
+// and returns the joined conversation.
+func (c *Client) ConversationsJoin(id string) (Conversation, error) {
+	var resp struct {
+		Response
+		Channel Conversation `json:"channel"`
+	}
+	if err := c.do(&resp, "conversations.join", "channel="+id); err != nil {
+		return Conversation{}, err
+	}
+	if !resp.OK {
+		return Conversation{}, ResponseError{resp.Response}
+	}
+	return resp.Channel, nil
+}
+
+// ConversationsSetTopic sets the topic of the conversation with the given ID
+// and returns the updated conversation.
+func (c *Client) ConversationsSetTopic(id, topic string) (Conversation, error) {
+	var resp struct {
+		Response
+		Channel Conversation `json:"channel"`
+	}
+	if err := c.do(&resp, "conversations.setTopic", "channel="+id, "topic="+topic); err != nil {
+		return Conversation{}, err
+	}
+	if !resp.OK {
+		return Conversation{}, ResponseError{resp.Response}
+	}
+	return resp.Channel, nil
+}
+
+// ConversationsMembers returns the user IDs
+// of the members of the conversation with the given ID.
+func (c *Client) ConversationsMembers(id string) ([]string, error) {
+	var members []string
+	p := pager{c: c, method: "conversations.members", args: []string{"channel=" + id}}
+	for {
+		var resp struct {
+			Response
+			Members []string `json:"members"`
+		}
+		switch err := p.page(&resp, &resp.Response); {
+		case err == io.EOF:
+			return members, nil
+		case err != nil:
+			return nil, err
+		}
+		members = append(members, resp.Members...)
+	}
+}
+
+// ConversationsHistory returns up to n of the most recent messages
+// of the conversation with the given ID, most recent first.
+func (c *Client) ConversationsHistory(id string, n int) ([]Message, error) {
+	var msgs []Message
+	it := c.History(id)
+	if n < it.size() {
+		it.limit = n
+	}
+	for len(msgs) < n {
+		msg, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// History returns an iterator over the messages
+// of the conversation with the given ID, most recent first.
+func (c *Client) History(id string) *MessageIterator {
+	p := pager{c: c, method: "conversations.history", args: []string{"channel=" + id}}
+	return &MessageIterator{pager: p}
+}
+
+// ConversationsReplies returns the messages of the thread
+// with the given parent timestamp in the conversation with the given ID.
+// The first message is the parent.
+func (c *Client) ConversationsReplies(id, ts string) ([]Message, error) {
+	it := &MessageIterator{pager: pager{
+		c:      c,
+		method: "conversations.replies",
+		args:   []string{"channel=" + id, "ts=" + ts},
+	}}
+	var msgs []Message
+	for {
+		msg, err := it.Next()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// A MessageIterator iterates over the pages
+// of a conversations.history or conversations.replies response.
+type MessageIterator struct {
+	pager
+	msgs []Message
+}
+
+// Next returns the next message.
+// It returns io.EOF after the last message.
+func (it *MessageIterator) Next() (Message, error) {
+	for len(it.msgs) == 0 {
+		var resp struct {
+			Response
+			Messages []Message `json:"messages"`
+		}
+		if err := it.page(&resp, &resp.Response); err != nil {
+			return Message{}, err
+		}
+		it.msgs = resp.Messages
+	}
+	msg := it.msgs[0]
+	it.msgs = it.msgs[1:]
+	return msg, nil
+}
@@ -0,0 +1,187 @@
+package slack
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// A Directory is a cache of slack users, conversations, and user groups.
+// It is safe for concurrent use.
+type Directory struct {
+	mu     sync.RWMutex
+	users  map[string]User
+	convs  map[string]Conversation
+	groups map[string]UserGroup
+}
+
+// NewDirectory returns a new Directory seeded with all users from users.list.
+func NewDirectory(c *Client) (*Directory, error) {
+	d := &Directory{
+		users:  make(map[string]User),
+		convs:  make(map[string]Conversation),
+		groups: make(map[string]UserGroup),
+	}
+	it := c.Users()
+	for {
+		u, err := it.Next()
+		if err == io.EOF {
+			return d, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.users[u.ID] = u
+	}
+}
+
+// User returns the user with the given ID.
+func (d *Directory) User(id string) (User, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	u, ok := d.users[id]
+	return u, ok
+}
+
+// FetchUser returns the user with the given ID.
+// If the user is not in the directory,
+// it is fetched with users.info and added.
+func (d *Directory) FetchUser(c *Client, id string) (User, error) {
+	if u, ok := d.User(id); ok {
+		return u, nil
+	}
+	u, err := c.UsersInfo(id)
+	if err != nil {
+		return User{}, err
+	}
+	d.PutUser(u)
+	return u, nil
+}
+
+// UserByName returns the user with the given username.
+func (d *Directory) UserByName(name string) (User, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, u := range d.users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// PutUser adds or replaces a user.
+func (d *Directory) PutUser(u User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[u.ID] = u
+}
+
+// LoadConversations adds all conversations of the given types
+// from conversations.list.
+func (d *Directory) LoadConversations(c *Client, types ...string) error {
+	it := c.Conversations(types...)
+	for {
+		conv, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.PutConversation(conv)
+	}
+}
+
+// Conversation returns the conversation with the given ID.
+func (d *Directory) Conversation(id string) (Conversation, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	conv, ok := d.convs[id]
+	return conv, ok
+}
+
+// ConversationByName returns the conversation with the given name.
+func (d *Directory) ConversationByName(name string) (Conversation, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, conv := range d.convs {
+		if conv.Name == name {
+			return conv, true
+		}
+	}
+	return Conversation{}, false
+}
+
+// PutConversation adds or replaces a conversation.
+func (d *Directory) PutConversation(conv Conversation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.convs[conv.ID] = conv
+}
+
+// LoadUserGroups adds all user groups from usergroups.list.
+func (d *Directory) LoadUserGroups(c *Client) error {
+	groups, err := c.UserGroupsList()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, g := range groups {
+		d.groups[g.ID] = g
+	}
+	return nil
+}
+
+// UserGroup returns the user group with the given ID.
+func (d *Directory) UserGroup(id string) (UserGroup, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	g, ok := d.groups[id]
+	return g, ok
+}
+
+// Update updates the directory from a user_change, team_join,
+// channel_created, channel_rename, or group_rename event.
+// It reports whether the event was used.
+func (d *Directory) Update(event map[string]interface{}) bool {
+	switch t, _ := event["type"].(string); t {
+	case "user_change", "team_join":
+		var ev struct {
+			User User `json:"user"`
+		}
+		if err := DecodeEvent(event, &ev); err != nil || ev.User.ID == "" {
+			return false
+		}
+		d.PutUser(ev.User)
+		return true
+
+	case "channel_created", "channel_rename", "group_rename":
+		var ev struct {
+			Channel Conversation `json:"channel"`
+		}
+		if err := DecodeEvent(event, &ev); err != nil || ev.Channel.ID == "" {
+			return false
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		conv, ok := d.convs[ev.Channel.ID]
+		if !ok {
+			conv = ev.Channel
+		}
+		conv.Name = ev.Channel.Name
+		d.convs[conv.ID] = conv
+		return true
+	}
+	return false
+}
+
+// DecodeEvent decodes an event into the value pointed to by v.
+func DecodeEvent(event map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
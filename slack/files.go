@@ -0,0 +1,80 @@
+package slack
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// downloadClient is the HTTP client used to download files.
+var downloadClient = &http.Client{Timeout: 10 * time.Minute}
+
+// A File object describes a file shared in slack.
+type File struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// Mimetype is the MIME type of the file.
+	Mimetype string `json:"mimetype"`
+	// Filetype is slack's short name for the type of the file.
+	Filetype string `json:"filetype"`
+	// Size is the size of the file in bytes.
+	Size int64  `json:"size"`
+	User string `json:"user"`
+	// URLPrivate is the URL of the file contents.
+	// Downloading it requires authentication; see Download.
+	URLPrivate         string `json:"url_private"`
+	URLPrivateDownload string `json:"url_private_download"`
+	// Permalink is the URL of the file's page in slack.
+	Permalink string `json:"permalink"`
+	// PermalinkPublic is the public URL of the file, if it is shared publicly.
+	PermalinkPublic string `json:"permalink_public"`
+	IsPublic        bool   `json:"is_public"`
+	IsExternal      bool   `json:"is_external"`
+	// FileAccess is "check_file_info" if the file
+	// is only partially described, and FilesInfo must be used.
+	FileAccess string `json:"file_access"`
+}
+
+// FilesInfo returns the file with the given ID.
+func (c *Client) FilesInfo(id string) (File, error) {
+	var resp struct {
+		Response
+		File File `json:"file"`
+	}
+	if err := c.do(&resp, "files.info", "file="+id); err != nil {
+		return File{}, err
+	}
+	if !resp.OK {
+		return File{}, ResponseError{resp.Response}
+	}
+	return resp.File, nil
+}
+
+// Download writes the contents of the private file URL to w.
+// The request is authenticated with the client's token.
+// It fails if the file is larger than max bytes.
+func (c *Client) Download(url string, w io.Writer, max int64) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+	if resp.ContentLength > max {
+		return fmt.Errorf("download failed: file is larger than %d bytes", max)
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, max+1))
+	if err == nil && n > max {
+		err = fmt.Errorf("download failed: file is larger than %d bytes", max)
+	}
+	return err
+}
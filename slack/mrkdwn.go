@@ -0,0 +1,121 @@
+package slack
+
+import (
+	"html"
+	"strings"
+)
+
+// Escape returns text with the characters &, <, and > escaped
+// as slack requires for message text.
+func Escape(text string) string {
+	return escaper.Replace(text)
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Decode returns text, in slack's message format,
+// decoded to plain text for display outside of slack.
+//
+// User, channel, and user group references are replaced
+// by their names from the directory, or by their labels
+// if they are not in the directory.
+// Special mentions are replaced by @here, @channel, and @everyone.
+// Links are rendered as "label (url)", or just the url if it has no label.
+// The HTML entities &amp;, &lt;, and &gt; are unescaped.
+//
+// A nil Directory decodes using only the labels in the text.
+func (d *Directory) Decode(text string) string {
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(text, '<')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(text[i:], '>')
+		if j < 0 {
+			break
+		}
+		out.WriteString(html.UnescapeString(text[:i]))
+		out.WriteString(d.decodeEntity(text[i+1 : i+j]))
+		text = text[i+j+1:]
+	}
+	out.WriteString(html.UnescapeString(text))
+	return out.String()
+}
+
+// decodeEntity decodes the contents of a <…> entity.
+func (d *Directory) decodeEntity(ent string) string {
+	target, label := ent, ""
+	if i := strings.IndexByte(ent, '|'); i >= 0 {
+		target, label = ent[:i], html.UnescapeString(ent[i+1:])
+	}
+	switch {
+	case strings.HasPrefix(target, "@"):
+		id := target[1:]
+		if d != nil {
+			if u, ok := d.User(id); ok {
+				return "@" + u.DisplayName()
+			}
+		}
+		if label != "" {
+			return "@" + strings.TrimPrefix(label, "@")
+		}
+		return "@" + id
+
+	case strings.HasPrefix(target, "#"):
+		id := target[1:]
+		if d != nil {
+			if conv, ok := d.Conversation(id); ok && conv.Name != "" {
+				return "#" + conv.Name
+			}
+		}
+		if label != "" {
+			return "#" + strings.TrimPrefix(label, "#")
+		}
+		return "#" + id
+
+	case strings.HasPrefix(target, "!"):
+		return d.decodeSpecial(target[1:], label)
+
+	case strings.HasPrefix(target, "mailto:"):
+		addr := strings.TrimPrefix(target, "mailto:")
+		if label == "" || label == addr {
+			return addr
+		}
+		return label + " (" + addr + ")"
+
+	default:
+		url := html.UnescapeString(target)
+		if label == "" || label == url || "http://"+label == url || "https://"+label == url {
+			return url
+		}
+		return label + " (" + url + ")"
+	}
+}
+
+// decodeSpecial decodes the command of a <!…> entity.
+func (d *Directory) decodeSpecial(cmd, label string) string {
+	switch {
+	case cmd == "here" || cmd == "channel" || cmd == "everyone":
+		return "@" + cmd
+
+	case strings.HasPrefix(cmd, "subteam^"):
+		id := strings.TrimPrefix(cmd, "subteam^")
+		if d != nil {
+			if g, ok := d.UserGroup(id); ok && g.Handle != "" {
+				return "@" + g.Handle
+			}
+		}
+		if label != "" {
+			return "@" + strings.TrimPrefix(label, "@")
+		}
+		return "@" + id
+
+	case label != "":
+		// For example, <!date^1392734382^{date}|Feb 18, 2014>.
+		return label
+
+	default:
+		return "<" + cmd + ">"
+	}
+}
@@ -0,0 +1,59 @@
+package slack
+
+import "testing"
+
+func TestDecode(t *testing.T) {
+	d := &Directory{
+		users: map[string]User{
+			"U024BE7LH": {ID: "U024BE7LH", Name: "bob", Profile: Profile{DisplayName: "Bobby"}},
+		},
+		convs: map[string]Conversation{
+			"C024BE7LR": {ID: "C024BE7LR", Name: "random"},
+		},
+		groups: map[string]UserGroup{
+			"SAZ94GDB8": {ID: "SAZ94GDB8", Handle: "gophers"},
+		},
+	}
+	tests := []struct {
+		text, want string
+	}{
+		{"plain text", "plain text"},
+		{"a &lt; b &amp;&amp; b &gt; c", "a < b && b > c"},
+		{"hi <@U024BE7LH>", "hi @Bobby"},
+		{"hi <@U999|carol>", "hi @carol"},
+		{"hi <@U999>", "hi @U999"},
+		{"see <#C024BE7LR>", "see #random"},
+		{"see <#C123|general>", "see #general"},
+		{"<!here> <!channel> <!everyone|@everyone>", "@here @channel @everyone"},
+		{"hey <!here>", "hey @here"},
+		{"<!here|here>", "@here"},
+		{"<!here|@here>", "@here"},
+		{"<!channel>", "@channel"},
+		{"<!channel|channel>", "@channel"},
+		{"<!everyone>", "@everyone"},
+		{"<!everyone|everyone>", "@everyone"},
+		{"<!subteam^SAZ94GDB8>", "@gophers"},
+		{"<!subteam^SAZ94GDB8|@old-name>", "@gophers"},
+		{"<!subteam^S123|@team>", "@team"},
+		{"<!subteam^S123|team>", "@team"},
+		{"<!subteam^S123>", "@S123"},
+		{"<!date^1392734382^{date}|Feb 18, 2014>", "Feb 18, 2014"},
+		{"<https://x.com|label>", "label (https://x.com)"},
+		{"<https://x.com>", "https://x.com"},
+		{"<https://x.com|x.com>", "https://x.com"},
+		{"<https://x.com/?a=1&amp;b=2>", "https://x.com/?a=1&b=2"},
+		{"<mailto:bob@x.com|bob@x.com>", "bob@x.com"},
+		{"<mailto:bob@x.com|Bob>", "Bob (bob@x.com)"},
+		{"unterminated <@U024BE7LH", "unterminated <@U024BE7LH"},
+	}
+	for _, test := range tests {
+		if got := d.Decode(test.text); got != test.want {
+			t.Errorf("Decode(%q)=%q, want %q", test.text, got, test.want)
+		}
+	}
+
+	var nilDir *Directory
+	if got, want := nilDir.Decode("<@U024BE7LH|bob> <#C1>"), "@bob #C1"; got != want {
+		t.Errorf("nil Decode=%q, want %q", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultSendTimeout is the default time that Send waits for a reply.
+const DefaultSendTimeout = 10 * time.Second
+
+var (
+	// ErrTimeout is returned by Send if there is no reply in time.
+	ErrTimeout = errors.New("timed out waiting for reply")
+
+	// ErrDisconnected is returned by Send if the connection
+	// is lost before the reply is received.
+	ErrDisconnected = errors.New("disconnected before reply")
+)
+
+// A Reply is slack's reply to a message sent over the RTM connection.
+type Reply struct {
+	ReplyTo int  `json:"reply_to"`
+	OK      bool `json:"ok"`
+	// TS is the timestamp of a sent message.
+	TS   string `json:"ts"`
+	Text string `json:"text"`
+	// Error is set if OK is false.
+	Error *ReplyError `json:"error"`
+}
+
+// A ReplyError is the error of a reply with ok=false.
+type ReplyError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (err *ReplyError) Error() string {
+	return fmt.Sprintf("reply error %d: %s", err.Code, err.Msg)
+}
+
+// An UnmatchedReplyError is returned by Next for a reply
+// that does not match any pending Send.
+// This can happen if Send timed out before the reply arrived.
+type UnmatchedReplyError struct {
+	Reply Reply
+}
+
+func (err *UnmatchedReplyError) Error() string {
+	return fmt.Sprintf("unmatched reply to %d", err.Reply.ReplyTo)
+}
+
+type rtmReply struct {
+	Reply
+	err error
+}
+
+// reply delivers a reply event to the pending Send.
+func (c *Client) reply(event map[string]interface{}) error {
+	var r Reply
+	if err := DecodeEvent(event, &r); err != nil {
+		return err
+	}
+	c.Lock()
+	ch, ok := c.pending[r.ReplyTo]
+	delete(c.pending, r.ReplyTo)
+	c.Unlock()
+	if !ok {
+		return &UnmatchedReplyError{Reply: r}
+	}
+	var err error
+	if !r.OK {
+		err = r.Error
+		if r.Error == nil {
+			err = &ReplyError{Msg: "unknown error"}
+		}
+	}
+	ch <- rtmReply{Reply: r, err: err}
+	return nil
+}
+
+// failPending fails all pending Sends with the given error.
+func (c *Client) failPending(err error) {
+	c.Lock()
+	defer c.Unlock()
+	for id, ch := range c.pending {
+		ch <- rtmReply{err: err}
+		delete(c.pending, id)
+	}
+}
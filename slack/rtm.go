@@ -0,0 +1,257 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// pingInterval is the time between pings.
+	pingInterval = 10 * time.Second
+
+	// pongTimeout is the time after the last pong
+	// at which a connection is considered dead.
+	pongTimeout = 3 * pingInterval
+
+	// reconnectURLTTL is the time after which
+	// a reconnect_url is no longer used.
+	reconnectURLTTL = 30 * time.Second
+
+	// maxBackoff is the maximum delay between reconnect attempts.
+	maxBackoff = time.Minute
+)
+
+var (
+	// ErrClosed is returned by Next and Close after the Client is closed.
+	ErrClosed = errors.New("client closed")
+
+	errGoodbye = errors.New("goodbye from server")
+)
+
+// A ConnState is the state of a Client's RTM connection.
+type ConnState int
+
+const (
+	// Connected indicates that a new connection is established.
+	Connected ConnState = iota
+	// Disconnected indicates that the connection was lost.
+	Disconnected
+	// Reconnecting indicates that a reconnect attempt is starting.
+	Reconnecting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// An rtmConn is a single RTM websocket connection and its ping goroutine.
+type rtmConn struct {
+	ws   *websocket.Conn
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+	err  error
+
+	sync.Mutex
+	lastPong time.Time
+}
+
+// connect starts a new RTM session, waits for the hello event,
+// and starts pinging.
+func (c *Client) connect() (*rtmConn, error) {
+	var resp struct {
+		Response
+		URL  string `json:"url"`
+		Self struct {
+			ID string `json:"id"`
+		} `json:"self"`
+	}
+	if err := c.do(&resp, "rtm.start"); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, ResponseError{resp.Response}
+	}
+	conn, err := c.dialRTM(resp.URL)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	c.id = resp.Self.ID
+	c.Unlock()
+	return conn, nil
+}
+
+func (c *Client) dialRTM(url string) (*rtmConn, error) {
+	ws, err := c.dial(url, c.api.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := &rtmConn{ws: ws, stop: make(chan struct{}), lastPong: time.Now()}
+	event, err := conn.receive()
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if hello, ok := event["type"].(string); !ok || hello != "hello" {
+		ws.Close()
+		return nil, fmt.Errorf("expected hello, got %v", event)
+	}
+	conn.wg.Add(1)
+	go c.ping(conn)
+	return conn, nil
+}
+
+// reconnect closes old, which failed with the given error,
+// and replaces it with a new connection.
+// It retries with exponential backoff until it succeeds,
+// the Client is closed, or Slack rejects the token.
+func (c *Client) reconnect(old *rtmConn, cause error) error {
+	old.close()
+	c.failPending(ErrDisconnected)
+	c.setState(Disconnected, cause)
+
+	backoff := time.Second
+	for {
+		c.Lock()
+		closed := c.closed
+		url := c.reconnectURL
+		fresh := url != "" && time.Since(c.reconnectAt) < reconnectURLTTL
+		c.reconnectURL = ""
+		c.Unlock()
+		if closed {
+			return ErrClosed
+		}
+
+		c.setState(Reconnecting, nil)
+		var conn *rtmConn
+		var err error
+		if fresh {
+			conn, err = c.dialRTM(url)
+		} else {
+			conn, err = c.connect()
+		}
+		if err == nil {
+			c.Lock()
+			closed := c.closed
+			if !closed {
+				c.conn = conn
+			}
+			c.Unlock()
+			if closed {
+				conn.close()
+				return ErrClosed
+			}
+			c.setState(Connected, nil)
+			return nil
+		}
+		if isAuthError(err) {
+			return err
+		}
+		c.setState(Disconnected, err)
+		select {
+		case <-c.done:
+			return ErrClosed
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) setState(s ConnState, err error) {
+	c.Lock()
+	f := c.stateHook
+	c.Unlock()
+	if f != nil {
+		f(s, err)
+	}
+}
+
+// isAuthError returns whether the error indicates
+// that retrying with the same token will never succeed.
+func isAuthError(err error) bool {
+	respErr, ok := err.(ResponseError)
+	if !ok {
+		return false
+	}
+	switch respErr.Response.Error {
+	case "not_authed", "invalid_auth", "account_inactive", "token_revoked", "missing_scope":
+		return true
+	}
+	return false
+}
+
+func (conn *rtmConn) receive() (map[string]interface{}, error) {
+	event := make(map[string]interface{})
+	if err := websocket.JSON.Receive(conn.ws, &event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (conn *rtmConn) send(message map[string]interface{}) error {
+	return websocket.JSON.Send(conn.ws, message)
+}
+
+func (conn *rtmConn) pong() {
+	conn.Lock()
+	conn.lastPong = time.Now()
+	conn.Unlock()
+}
+
+// ping sends pings on conn until it is closed.
+// If a ping fails or pongs stop arriving,
+// ping closes the websocket, so the reader sees an error.
+func (c *Client) ping(conn *rtmConn) {
+	defer conn.wg.Done()
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.stop:
+			return
+		case <-ticker.C:
+			conn.Lock()
+			last := conn.lastPong
+			conn.Unlock()
+			if time.Since(last) > pongTimeout {
+				conn.ws.Close()
+				return
+			}
+			c.Lock()
+			id := c.nextID
+			c.nextID++
+			c.Unlock()
+			if err := conn.send(map[string]interface{}{"type": "ping", "id": id}); err != nil {
+				conn.ws.Close()
+				return
+			}
+		}
+	}
+}
+
+// close closes the websocket and waits for the ping goroutine to return.
+// It is safe to call close multiple times.
+func (conn *rtmConn) close() error {
+	conn.once.Do(func() {
+		close(conn.stop)
+		conn.err = conn.ws.Close()
+		conn.wg.Wait()
+	})
+	return conn.err
+}
@@ -3,10 +3,11 @@ package slack
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +19,49 @@ var (
 	api = url.URL{Scheme: "https", Host: "slack.com", Path: "/api"}
 )
 
+// A Dialer dials a websocket connection to url with the given origin.
+type Dialer func(url, origin string) (*websocket.Conn, error)
+
+func defaultDialer(url, origin string) (*websocket.Conn, error) {
+	return websocket.Dial(url, "", origin)
+}
+
+// An Option configures a Client.
+type Option func(*Client) error
+
+// WithAPIURL returns an Option that sets the base URL of the Web API.
+// The default is https://slack.com/api.
+func WithAPIURL(rawurl string) Option {
+	return func(c *Client) error {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return err
+		}
+		c.api = *u
+		return nil
+	}
+}
+
+// WithSendTimeout returns an Option that sets how long Send
+// waits for a reply.
+// The default is DefaultSendTimeout.
+func WithSendTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		c.sendTimeout = d
+		return nil
+	}
+}
+
+// WithDialer returns an Option that sets the Dialer
+// used to connect to the RTM websocket.
+// The default uses websocket.Dial.
+func WithDialer(d Dialer) Option {
+	return func(c *Client) error {
+		c.dial = d
+		return nil
+	}
+}
+
 // A ResponseError is a slack response with ok=false and an error message.
 type ResponseError struct{ Response }
 
@@ -27,20 +71,70 @@ func (err ResponseError) Error() string {
 
 // Response is a header common to all slack responses.
 type Response struct {
-	OK      bool   `json:"ok"`
-	Error   string `json:"error"`
-	Warning string `json:"warning"`
+	OK       bool             `json:"ok"`
+	Error    string           `json:"error"`
+	Warning  string           `json:"warning"`
+	Metadata ResponseMetadata `json:"response_metadata"`
+}
+
+// ResponseMetadata is the metadata of a slack response.
+type ResponseMetadata struct {
+	// NextCursor is the cursor of the next page of a paginated response.
+	// It is empty on the last page.
+	NextCursor string `json:"next_cursor"`
 }
 
 // A User object describes a slack user.
 type User struct {
 	ID string `json:"id"`
 	// Name is the username without a leading @.
-	Name string `json:"name"`
-	// BUG(eaburns): Add remaining User object fields.
+	Name     string  `json:"name"`
+	Deleted  bool    `json:"deleted"`
+	IsBot    bool    `json:"is_bot"`
+	IsAdmin  bool    `json:"is_admin"`
+	IsOwner  bool    `json:"is_owner"`
+	TZ       string  `json:"tz"`
+	TZLabel  string  `json:"tz_label"`
+	TZOffset int     `json:"tz_offset"`
+	Profile  Profile `json:"profile"`
+}
+
+// DisplayName returns the name that slack shows for the user:
+// the profile display name if set,
+// otherwise the profile real name if set,
+// otherwise the username.
+func (u User) DisplayName() string {
+	switch {
+	case u.Profile.DisplayName != "":
+		return u.Profile.DisplayName
+	case u.Profile.RealName != "":
+		return u.Profile.RealName
+	default:
+		return u.Name
+	}
+}
+
+// A Profile object describes the profile of a slack user.
+type Profile struct {
+	DisplayName   string `json:"display_name"`
+	RealName      string `json:"real_name"`
+	Title         string `json:"title"`
+	Email         string `json:"email"`
+	StatusText    string `json:"status_text"`
+	StatusEmoji   string `json:"status_emoji"`
+	Image24       string `json:"image_24"`
+	Image32       string `json:"image_32"`
+	Image48       string `json:"image_48"`
+	Image72       string `json:"image_72"`
+	Image192      string `json:"image_192"`
+	Image512      string `json:"image_512"`
+	ImageOriginal string `json:"image_original"`
 }
 
 // A Channel object describes a slack channel.
+//
+// Deprecated: Channel is returned by the deprecated
+// channels.list and groups.list methods; use Conversation.
 type Channel struct {
 	ID string `json:"id"`
 	// Name is the name of the channel without a leading #.
@@ -48,167 +142,494 @@ type Channel struct {
 	IsArchived bool   `json:"is_archived"`
 }
 
+// DefaultPageSize is the page size used for paginated methods
+// when Client.PageSize is zero.
+const DefaultPageSize = 200
+
 // A Client represents a connection to the slack API.
 type Client struct {
-	token   string
-	id      string
-	webSock *websocket.Conn
-	done    chan chan<- error
+	// PageSize is the maximum number of items requested
+	// per page from paginated methods.
+	// If zero, DefaultPageSize is used.
+	PageSize int
+
+	token string
+	id    string
+	api   url.URL
+	dial  Dialer
+
+	conn         *rtmConn
+	closed       bool
+	done         chan struct{}
+	reconnectURL string
+	reconnectAt  time.Time
+	stateHook    func(ConnState, error)
+
+	nextID      int
+	pending     map[int]chan<- rtmReply
+	sendTimeout time.Duration
 
-	nextID int
+	// botID is the bot ID of messages posted by the client,
+	// learned from chat.postMessage responses.
+	botID string
+	// usernames are the usernames that the client has posted as.
+	usernames map[string]bool
 	sync.Mutex
 }
 
-// NewClient returns a new slack client using the given token.
+// NewClient returns a new slack client using the given token and options.
 // The returned Client is connected to the RTM endpoint
 // and automatically sends pings.
-func NewClient(token string) (*Client, error) {
-	c := &Client{token: token, done: make(chan chan<- error)}
-
-	var resp struct {
-		Response
-		URL  string `json:"url"`
-		Self struct {
-			ID string `json:"id"`
-		} `json:"self"`
-	}
-	if err := c.do(&resp, "rtm.start"); err != nil {
-		return nil, err
+// If the RTM connection is lost, the Client reconnects
+// the next time Next is called.
+func NewClient(token string, opts ...Option) (*Client, error) {
+	c := &Client{
+		token:       token,
+		api:         api,
+		dial:        defaultDialer,
+		done:        make(chan struct{}),
+		pending:     make(map[int]chan<- rtmReply),
+		usernames:   make(map[string]bool),
+		sendTimeout: DefaultSendTimeout,
 	}
-	if !resp.OK {
-		return nil, ResponseError{resp.Response}
-	}
-	webSock, err := websocket.Dial(resp.URL, "", api.String())
-	if err != nil {
-		return nil, err
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
 	}
-	c.webSock = webSock
-	c.id = resp.Self.ID
-
-	event, err := c.Next()
+	conn, err := c.connect()
 	if err != nil {
 		return nil, err
 	}
-	if hello, ok := event["type"].(string); !ok || hello != "hello" {
-		return nil, fmt.Errorf("expected hello, got %v", event)
-	}
-
-	go ping(c)
-
+	c.conn = conn
 	return c, nil
 }
 
-func ping(c *Client) {
-	ticker := time.NewTicker(10 * time.Second)
-	for {
-		select {
-		case ch := <-c.done:
-			ticker.Stop()
-			ch <- c.webSock.Close()
-			return
-		case <-ticker.C:
-			if err := c.Send(map[string]interface{}{"type": "ping"}); err != nil {
-				ticker.Stop()
-				c.webSock.Close()
-				<-c.done <- fmt.Errorf("failed to send ping: %v", err)
-				return
-			}
-		}
-	}
-}
-
 // Close closes the connection.
+// Calls to Next that are blocked or made after Close return ErrClosed.
 func (c *Client) Close() error {
-	ch := make(chan error)
-	c.done <- ch
-	return <-ch
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.Unlock()
+	c.failPending(ErrClosed)
+	if conn == nil {
+		return nil
+	}
+	return conn.close()
 }
 
 // ID returns the client's ID.
-func (c *Client) ID() string { return c.id }
+func (c *Client) ID() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.id
+}
+
+// OnStateChange sets a function that is called
+// each time the state of the RTM connection changes.
+// The error is the cause of a Disconnected state, and nil otherwise.
+// The function is called from the goroutine calling Next.
+func (c *Client) OnStateChange(f func(ConnState, error)) {
+	c.Lock()
+	c.stateHook = f
+	c.Unlock()
+}
 
 // Next returns the next event from Slack.
-// It never returns pong type messages.
+// It never returns pong, reconnect_url, or goodbye type messages,
+// nor replies to messages sent with Send.
+// If a reply does not match a pending Send,
+// Next returns an *UnmatchedReplyError;
+// the connection remains usable.
+//
+// If the connection is closed by Slack, fails, or stops receiving pongs,
+// Next reconnects and continues with events from the new connection.
+// Next must be called continually; pongs are only noticed by Next.
 func (c *Client) Next() (map[string]interface{}, error) {
 	for {
-		event := make(map[string]interface{})
-		if err := websocket.JSON.Receive(c.webSock, &event); err != nil {
-			return nil, err
+		c.Lock()
+		conn, closed := c.conn, c.closed
+		c.Unlock()
+		if closed {
+			return nil, ErrClosed
 		}
-		if t, ok := event["type"].(string); !ok || t != "pong" {
+
+		event, err := conn.receive()
+		if err != nil {
+			if err := c.reconnect(conn, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch t, _ := event["type"].(string); t {
+		case "pong":
+			conn.pong()
+		case "reconnect_url":
+			url, _ := event["url"].(string)
+			c.Lock()
+			c.reconnectURL = url
+			c.reconnectAt = time.Now()
+			c.Unlock()
+		case "goodbye":
+			if err := c.reconnect(conn, errGoodbye); err != nil {
+				return nil, err
+			}
+		default:
+			if _, ok := event["reply_to"]; ok {
+				if err := c.reply(event); err != nil {
+					return nil, err
+				}
+				break
+			}
 			return event, nil
 		}
 	}
 }
 
-// Send sets the "id" field of the message to the next ID and sends it.
-// It does not await a response.
-func (c *Client) Send(message map[string]interface{}) error {
+// Send sets the "id" field of the message to the next ID, sends it,
+// and waits for slack's reply.
+// If slack replies with an error, Send returns a *ReplyError.
+// If there is no reply within the send timeout, Send returns ErrTimeout.
+//
+// Replies are received by Next,
+// so Next must be called concurrently with Send.
+func (c *Client) Send(message map[string]interface{}) (Reply, error) {
+	ch := make(chan rtmReply, 1)
 	c.Lock()
-	message["id"] = c.nextID
+	conn := c.conn
+	id := c.nextID
 	c.nextID++
+	message["id"] = id
+	c.pending[id] = ch
+	timeout := c.sendTimeout
 	c.Unlock()
-	return websocket.JSON.Send(c.webSock, message)
+	defer func() {
+		c.Lock()
+		delete(c.pending, id)
+		c.Unlock()
+	}()
+
+	if err := conn.send(message); err != nil {
+		return Reply{}, err
+	}
+	select {
+	case r := <-ch:
+		return r.Reply, r.err
+	case <-time.After(timeout):
+		return Reply{}, ErrTimeout
+	}
 }
 
 // UsersList returns a list of all slack users.
 func (c *Client) UsersList() ([]User, error) {
+	var users []User
+	it := c.Users()
+	for {
+		u, err := it.Next()
+		if err == io.EOF {
+			return users, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+}
+
+// UsersInfo returns the user with the given ID.
+func (c *Client) UsersInfo(id string) (User, error) {
 	var resp struct {
 		Response
-		Members []User `json:"members"`
+		User User `json:"user"`
 	}
-	if err := c.do(&resp, "users.list"); err != nil {
-		return nil, err
+	if err := c.do(&resp, "users.info", "user="+id); err != nil {
+		return User{}, err
 	}
 	if !resp.OK {
-		return nil, ResponseError{resp.Response}
+		return User{}, ResponseError{resp.Response}
 	}
-	return resp.Members, nil
+	return resp.User, nil
+}
+
+// Users returns an iterator over all slack users.
+func (c *Client) Users() *UserIterator {
+	return &UserIterator{pager: pager{c: c, method: "users.list"}}
+}
+
+// A UserIterator iterates over the pages of a users.list response.
+type UserIterator struct {
+	pager
+	users []User
+}
+
+// Next returns the next user.
+// It returns io.EOF after the last user.
+func (it *UserIterator) Next() (User, error) {
+	for len(it.users) == 0 {
+		var resp struct {
+			Response
+			Members []User `json:"members"`
+		}
+		if err := it.page(&resp, &resp.Response); err != nil {
+			return User{}, err
+		}
+		it.users = resp.Members
+	}
+	u := it.users[0]
+	it.users = it.users[1:]
+	return u, nil
 }
 
 // ChannelsList returns a list of all slack channels.
+//
+// Deprecated: use ConversationsList.
 func (c *Client) ChannelsList() ([]Channel, error) {
-	var resp struct {
-		Response
-		Channels []Channel `json:"channels"`
+	return collectChannels(c.Channels())
+}
+
+// Channels returns an iterator over all slack channels.
+//
+// Deprecated: use Conversations.
+func (c *Client) Channels() *ChannelIterator {
+	return &ChannelIterator{pager: pager{c: c, method: "channels.list"}, field: "channels"}
+}
+
+// GroupsList returns a list of all slack groups — private channels.
+//
+// Deprecated: use ConversationsList with PrivateChannel.
+func (c *Client) GroupsList() ([]Channel, error) {
+	return collectChannels(c.Groups())
+}
+
+// Groups returns an iterator over all slack groups — private channels.
+//
+// Deprecated: use Conversations with PrivateChannel.
+func (c *Client) Groups() *ChannelIterator {
+	return &ChannelIterator{pager: pager{c: c, method: "groups.list"}, field: "groups"}
+}
+
+func collectChannels(it *ChannelIterator) ([]Channel, error) {
+	var chs []Channel
+	for {
+		ch, err := it.Next()
+		if err == io.EOF {
+			return chs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chs = append(chs, ch)
 	}
-	if err := c.do(&resp, "channels.list"); err != nil {
-		return nil, err
+}
+
+// A ChannelIterator iterates over the pages
+// of a channels.list or groups.list response.
+type ChannelIterator struct {
+	pager
+	field    string
+	channels []Channel
+}
+
+// Next returns the next channel.
+// It returns io.EOF after the last channel.
+func (it *ChannelIterator) Next() (Channel, error) {
+	for len(it.channels) == 0 {
+		var resp struct {
+			Response
+			Channels []Channel `json:"channels"`
+			Groups   []Channel `json:"groups"`
+		}
+		if err := it.page(&resp, &resp.Response); err != nil {
+			return Channel{}, err
+		}
+		if it.field == "groups" {
+			it.channels = resp.Groups
+		} else {
+			it.channels = resp.Channels
+		}
 	}
-	if !resp.OK {
-		return nil, ResponseError{resp.Response}
+	ch := it.channels[0]
+	it.channels = it.channels[1:]
+	return ch, nil
+}
+
+// A pager requests successive pages of a cursor-paginated method.
+type pager struct {
+	c      *Client
+	method string
+	args   []string
+	cursor string
+	done   bool
+	// limit is the number of items requested per page,
+	// or zero for the client's PageSize.
+	limit int
+}
+
+// size returns the number of items requested per page.
+func (p *pager) size() int {
+	switch {
+	case p.limit > 0:
+		return p.limit
+	case p.c.PageSize > 0:
+		return p.c.PageSize
 	}
-	return resp.Channels, nil
+	return DefaultPageSize
 }
 
-// GroupsList returns a list of all slack groups — private channels.
-func (c *Client) GroupsList() ([]Channel, error) {
+// page decodes the next page into resp, whose Response header is hdr.
+// It returns io.EOF if there are no more pages.
+func (p *pager) page(resp interface{}, hdr *Response) error {
+	if p.done {
+		return io.EOF
+	}
+	args := append([]string{"limit=" + strconv.Itoa(p.size())}, p.args...)
+	if p.cursor != "" {
+		args = append(args, "cursor="+p.cursor)
+	}
+	if err := p.c.do(resp, p.method, args...); err != nil {
+		return err
+	}
+	if !hdr.OK {
+		return ResponseError{*hdr}
+	}
+	p.cursor = hdr.Metadata.NextCursor
+	p.done = p.cursor == ""
+	return nil
+}
+
+// PostMessage posts a message to the server with as the given username.
+func (c *Client) PostMessage(username, iconurl, channel, text string) error {
+	_, err := c.Post(PostParams{
+		Channel:  channel,
+		Text:     text,
+		Username: username,
+		IconURL:  iconurl,
+	})
+	return err
+}
+
+// PostParams are the parameters of a chat.postMessage call.
+type PostParams struct {
+	Channel string
+	// Text is the message text.
+	// If Blocks are given, Text is the fallback text
+	// shown in notifications.
+	Text string
+	// Blocks are the Block Kit blocks of the message.
+	Blocks []Block
+	// Attachments are the legacy attachments of the message.
+	Attachments []Attachment
+	// Username is the username to post as.
+	// If empty, the message is posted as the authenticated user.
+	Username string
+	IconURL  string
+	// ThreadTS is the timestamp of the parent message
+	// if the message is a thread reply.
+	ThreadTS string
+	// ReplyBroadcast is whether a thread reply
+	// is also shown in the channel.
+	ReplyBroadcast bool
+}
+
+// Post posts a message and returns its timestamp.
+func (c *Client) Post(p PostParams) (string, error) {
+	args := []string{
+		"channel=" + p.Channel,
+		"text=" + p.Text,
+	}
+	if p.Username != "" {
+		args = append(args, "username="+p.Username, "as_user=false")
+	}
+	if p.IconURL != "" {
+		args = append(args, "icon_url="+p.IconURL)
+	}
+	if p.ThreadTS != "" {
+		args = append(args, "thread_ts="+p.ThreadTS)
+	}
+	if p.ReplyBroadcast {
+		args = append(args, "reply_broadcast=true")
+	}
+	if len(p.Blocks) > 0 {
+		data, err := json.Marshal(p.Blocks)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "blocks="+string(data))
+	}
+	if len(p.Attachments) > 0 {
+		data, err := json.Marshal(p.Attachments)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "attachments="+string(data))
+	}
+	if p.Username != "" {
+		c.Lock()
+		c.usernames[p.Username] = true
+		c.Unlock()
+	}
 	var resp struct {
 		Response
-		Groups []Channel `json:"groups"`
+		TS      string  `json:"ts"`
+		Message Message `json:"message"`
 	}
-	if err := c.do(&resp, "groups.list"); err != nil {
-		return nil, err
+	if err := c.do(&resp, "chat.postMessage", args...); err != nil {
+		return "", err
 	}
 	if !resp.OK {
-		return nil, ResponseError{resp.Response}
+		return "", ResponseError{resp.Response}
 	}
-	return resp.Groups, nil
+	if resp.Message.BotID != "" {
+		c.Lock()
+		c.botID = resp.Message.BotID
+		c.Unlock()
+	}
+	return resp.TS, nil
 }
 
-// PostMessage posts a message to the server with as the given username.
-func (c *Client) PostMessage(username, iconurl, channel, text string) error {
-	if iconurl != "" {
-		iconurl = "icon_url=" + iconurl
+// PostedBySelf returns whether the message is a bot message
+// posted by the client with Post and a Username.
+// Bot messages are compared by bot ID once it is known
+// from a Post response, and by username before then.
+// Messages posted as the authenticated user are indistinguishable
+// from those the user sent, so they are not reported.
+func (c *Client) PostedBySelf(m Message) bool {
+	c.Lock()
+	defer c.Unlock()
+	switch {
+	case m.BotID == "":
+		return false
+	case c.botID != "":
+		return m.BotID == c.botID
+	default:
+		return c.usernames[m.Username]
 	}
+}
+
+// ChatUpdate replaces the text of the message
+// with the given timestamp in the given channel.
+func (c *Client) ChatUpdate(channel, ts, text string) error {
 	var resp Response
-	err := c.do(&resp, "chat.postMessage",
-		"username="+username,
-		iconurl,
-		"as_user=false",
-		"channel="+channel,
-		"text="+text)
-	if err != nil {
+	if err := c.do(&resp, "chat.update", "channel="+channel, "ts="+ts, "text="+text); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return ResponseError{resp}
+	}
+	return nil
+}
+
+// ChatDelete deletes the message
+// with the given timestamp in the given channel.
+func (c *Client) ChatDelete(channel, ts string) error {
+	var resp Response
+	if err := c.do(&resp, "chat.delete", "channel="+channel, "ts="+ts); err != nil {
 		return err
 	}
 	if !resp.OK {
@@ -218,7 +639,7 @@ func (c *Client) PostMessage(username, iconurl, channel, text string) error {
 }
 
 func (c *Client) do(resp interface{}, method string, args ...string) error {
-	u := api
+	u := c.api
 	u.Path = path.Join(u.Path, method)
 	vals := make(url.Values)
 	vals["token"] = []string{c.token}
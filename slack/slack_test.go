@@ -0,0 +1,273 @@
+package slack_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/velour/relay/slack"
+	"github.com/velour/relay/slack/slacktest"
+)
+
+func newClient(t *testing.T, s *slacktest.Server) *slack.Client {
+	c, err := slack.NewClient("token", slack.WithAPIURL(s.URL))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	return c
+}
+
+func TestUsersPagination(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	for i := 0; i < 7; i++ {
+		s.AddUser(slack.User{ID: fmt.Sprintf("U%d", i), Name: fmt.Sprintf("user%d", i)})
+	}
+	c := newClient(t, s)
+	defer c.Close()
+	c.PageSize = 3
+
+	users, err := c.UsersList()
+	if err != nil {
+		t.Fatalf("UsersList failed: %v", err)
+	}
+	if len(users) != 7 {
+		t.Fatalf("len(UsersList())=%d, want 7", len(users))
+	}
+	for i, u := range users {
+		if want := fmt.Sprintf("U%d", i); u.ID != want {
+			t.Errorf("users[%d].ID=%q, want %q", i, u.ID, want)
+		}
+	}
+
+	it := c.Users()
+	for i := 0; i < 7; i++ {
+		if _, err := it.Next(); err != nil {
+			t.Fatalf("Next() #%d failed: %v", i, err)
+		}
+	}
+	if _, err := it.Next(); err != io.EOF {
+		t.Errorf("Next() after last user=%v, want io.EOF", err)
+	}
+}
+
+func TestConversationsList(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddConversation(slack.Conversation{ID: "C1", Name: "general", IsChannel: true})
+	s.AddConversation(slack.Conversation{ID: "G1", Name: "secret", IsGroup: true, IsPrivate: true})
+	s.AddConversation(slack.Conversation{ID: "D1", IsIM: true, User: "U1"})
+	c := newClient(t, s)
+	defer c.Close()
+
+	tests := []struct {
+		types []string
+		ids   []string
+	}{
+		{types: nil, ids: []string{"C1"}},
+		{types: []string{slack.PrivateChannel}, ids: []string{"G1"}},
+		{types: []string{slack.PublicChannel, slack.PrivateChannel}, ids: []string{"C1", "G1"}},
+		{types: []string{slack.IM}, ids: []string{"D1"}},
+	}
+	for _, test := range tests {
+		convs, err := c.ConversationsList(test.types...)
+		if err != nil {
+			t.Errorf("ConversationsList(%v) failed: %v", test.types, err)
+			continue
+		}
+		var ids []string
+		for _, conv := range convs {
+			ids = append(ids, conv.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("ConversationsList(%v)=%v, want %v", test.types, ids, test.ids)
+		}
+	}
+}
+
+func TestConversationsSetTopic(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddConversation(slack.Conversation{ID: "C1", Name: "general", IsChannel: true})
+	c := newClient(t, s)
+	defer c.Close()
+
+	if _, err := c.ConversationsSetTopic("C1", "hello"); err != nil {
+		t.Fatalf("ConversationsSetTopic failed: %v", err)
+	}
+	conv, err := c.ConversationsInfo("C1")
+	if err != nil {
+		t.Fatalf("ConversationsInfo failed: %v", err)
+	}
+	if conv.Topic.Value != "hello" {
+		t.Errorf("Topic.Value=%q, want %q", conv.Topic.Value, "hello")
+	}
+	if _, err := c.ConversationsSetTopic("C2", "hello"); err == nil {
+		t.Errorf("ConversationsSetTopic(C2) succeeded, want channel_not_found")
+	}
+}
+
+func TestConversationsHistory(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	var limits []string
+	s.Handle("conversations.history", func(form map[string][]string) interface{} {
+		limits = append(limits, form["limit"]...)
+		return map[string]interface{}{
+			"ok":       true,
+			"messages": []slack.Message{{TS: "3"}, {TS: "2"}, {TS: "1"}},
+		}
+	})
+	c := newClient(t, s)
+	defer c.Close()
+
+	msgs, err := c.ConversationsHistory("C1", 2)
+	if err != nil {
+		t.Fatalf("ConversationsHistory failed: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].TS != "3" || msgs[1].TS != "2" {
+		t.Errorf("ConversationsHistory(C1, 2)=%+v, want messages 3 and 2", msgs)
+	}
+	if fmt.Sprint(limits) != "[2]" {
+		t.Errorf("requested limits %v, want [2]", limits)
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddConversation(slack.Conversation{ID: "C1", Name: "general", IsChannel: true})
+	c := newClient(t, s)
+	defer c.Close()
+
+	if err := c.PostMessage("alice", "", "C1", "hello, world"); err != nil {
+		t.Fatalf("PostMessage failed: %v", err)
+	}
+	posts := s.Posts()
+	if len(posts) != 1 || posts[0].Username != "alice" || posts[0].Text != "hello, world" {
+		t.Errorf("Posts()=%+v, want one post from alice", posts)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	defer c.Close()
+
+	states := make(chan slack.ConnState, 10)
+	c.OnStateChange(func(st slack.ConnState, _ error) { states <- st })
+
+	s.Goodbye()
+	s.SendMessage("C1", "U1", "after goodbye")
+	event, err := c.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if text, _ := event["text"].(string); text != "after goodbye" {
+		t.Errorf("Next()=%v, want the message after goodbye", event)
+	}
+
+	s.Disconnect()
+	s.SendMessage("C1", "U1", "after disconnect")
+	event, err = c.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if text, _ := event["text"].(string); text != "after disconnect" {
+		t.Errorf("Next()=%v, want the message after disconnect", event)
+	}
+
+	var got []slack.ConnState
+	for len(states) > 0 {
+		got = append(got, <-states)
+	}
+	want := []slack.ConnState{
+		slack.Disconnected, slack.Reconnecting, slack.Connected,
+		slack.Disconnected, slack.Reconnecting, slack.Connected,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("states=%v, want %v", got, want)
+	}
+}
+
+func TestNextAfterClose(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+
+	errs := make(chan error)
+	go func() {
+		_, err := c.Next()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	if err := <-errs; err != slack.ErrClosed {
+		t.Errorf("Next() after Close=%v, want ErrClosed", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	c := newClient(t, s)
+	defer c.Close()
+
+	events := make(chan map[string]interface{})
+	errs := make(chan error)
+	go func() {
+		for {
+			event, err := c.Next()
+			if err == slack.ErrClosed {
+				return
+			}
+			if err != nil {
+				errs <- err
+				continue
+			}
+			events <- event
+		}
+	}()
+
+	reply, err := c.Send(map[string]interface{}{"type": "message", "channel": "C1", "text": "hi"})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !reply.OK || reply.TS == "" || reply.Text != "hi" {
+		t.Errorf("Send()=%+v, want an OK reply with a timestamp", reply)
+	}
+
+	_, err = c.Send(map[string]interface{}{"type": "bogus"})
+	if _, ok := err.(*slack.ReplyError); !ok {
+		t.Errorf("Send(bogus)=%v, want a *ReplyError", err)
+	}
+
+	s.SendEvent(map[string]interface{}{"ok": true, "reply_to": 1000})
+	select {
+	case err := <-errs:
+		if _, ok := err.(*slack.UnmatchedReplyError); !ok {
+			t.Errorf("Next()=%v, want an *UnmatchedReplyError", err)
+		}
+	case event := <-events:
+		t.Errorf("Next()=%v, want an *UnmatchedReplyError", event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an unmatched reply")
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	c, err := slack.NewClient("token", slack.WithAPIURL(s.URL), slack.WithSendTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	defer c.Close()
+
+	// Next is not called, so the reply is never received.
+	if _, err := c.Send(map[string]interface{}{"type": "message", "text": "hi"}); err != slack.ErrTimeout {
+		t.Errorf("Send()=%v, want ErrTimeout", err)
+	}
+}
@@ -0,0 +1,743 @@
+// Package slacktest provides an in-process fake of the Slack Web API
+// and RTM websocket for testing.
+package slacktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velour/relay/slack"
+	"golang.org/x/net/websocket"
+)
+
+// A Post is a message posted with chat.postMessage.
+type Post struct {
+	Channel  string
+	Username string
+	IconURL  string
+	Text     string
+	ThreadTS string
+	TS       string
+	// Edits are the texts from each chat.update of the message.
+	Edits []string
+	// Deleted is whether the message was deleted with chat.delete.
+	Deleted bool
+	// Form is the complete form of the request.
+	Form map[string][]string
+}
+
+// A HandlerFunc handles a Web API method.
+// It is given the request form and returns the JSON response body.
+type HandlerFunc func(form map[string][]string) interface{}
+
+// A Server is a fake Slack server.
+type Server struct {
+	// URL is the base URL of the fake Web API,
+	// for use with slack.WithAPIURL.
+	URL string
+
+	// Token is the token accepted by the server.
+	// If empty, any token is accepted.
+	Token string
+
+	// SelfID is the user ID of the connected client.
+	SelfID string
+
+	// BotID is the bot ID reported for messages posted with chat.postMessage.
+	BotID string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	methods  map[string]HandlerFunc
+	users    []slack.User
+	groups   []slack.UserGroup
+	files    map[string]file
+	convs    []slack.Conversation
+	members  map[string][]string
+	history  map[string][]slack.Message
+	posts    []Post
+	conns    map[*rtmConn]bool
+	pending  []map[string]interface{}
+	nextTS   int
+	rtmCalls int
+}
+
+type file struct {
+	slack.File
+	content []byte
+}
+
+type rtmConn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *rtmConn) send(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return websocket.JSON.Send(c.ws, v)
+}
+
+// NewServer returns a new, started Server.
+// The caller must call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		SelfID:  "USELF",
+		BotID:   "BSELF",
+		methods: make(map[string]HandlerFunc),
+		members: make(map[string][]string),
+		history: make(map[string][]slack.Message),
+		conns:   make(map[*rtmConn]bool),
+		files:   make(map[string]file),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.serveAPI)
+	mux.Handle("/rtm", websocket.Handler(s.serveRTM))
+	mux.HandleFunc("/files/", s.serveFile)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL + "/api"
+
+	s.methods["rtm.start"] = s.rtmStart
+	s.methods["rtm.connect"] = s.rtmStart
+	s.methods["users.list"] = s.usersList
+	s.methods["users.info"] = s.usersInfo
+	s.methods["usergroups.list"] = s.userGroupsList
+	s.methods["conversations.list"] = s.conversationsList
+	s.methods["conversations.info"] = s.conversationsInfo
+	s.methods["conversations.join"] = s.conversationsJoin
+	s.methods["conversations.members"] = s.conversationsMembers
+	s.methods["conversations.setTopic"] = s.conversationsSetTopic
+	s.methods["conversations.history"] = s.conversationsHistory
+	s.methods["conversations.replies"] = s.conversationsReplies
+	s.methods["chat.postMessage"] = s.chatPostMessage
+	s.methods["files.info"] = s.filesInfo
+	s.methods["chat.update"] = s.chatUpdate
+	s.methods["chat.delete"] = s.chatDelete
+	return s
+}
+
+// Close closes all RTM connections and shuts down the server.
+func (s *Server) Close() {
+	s.Disconnect()
+	s.srv.Close()
+}
+
+// Handle sets the handler for a Web API method,
+// replacing any existing handler.
+func (s *Server) Handle(method string, f HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[method] = f
+}
+
+// AddUser adds a user to the server.
+func (s *Server) AddUser(u slack.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = append(s.users, u)
+}
+
+// AddUserGroup adds a user group to the server.
+func (s *Server) AddUserGroup(g slack.UserGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups = append(s.groups, g)
+}
+
+// AddFile adds a file with the given contents and returns it
+// with its URLPrivate and Permalink set to URLs on the server.
+func (s *Server) AddFile(f slack.File, content []byte) slack.File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.URLPrivate = s.srv.URL + "/files/" + f.ID
+	f.URLPrivateDownload = f.URLPrivate
+	f.Permalink = s.srv.URL + "/permalink/" + f.ID
+	f.Size = int64(len(content))
+	s.files[f.ID] = file{File: f, content: content}
+	return f
+}
+
+// AddConversation adds a conversation with the given member user IDs.
+func (s *Server) AddConversation(c slack.Conversation, members ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.convs = append(s.convs, c)
+	s.members[c.ID] = members
+}
+
+// AddMessage adds a message to the history of its channel.
+func (s *Server) AddMessage(m slack.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[m.Channel] = append(s.history[m.Channel], m)
+}
+
+// Posts returns the messages posted with chat.postMessage so far.
+func (s *Server) Posts() []Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Post(nil), s.posts...)
+}
+
+// WaitForPosts waits until at least n messages are posted
+// or the timeout expires, and returns the posted messages.
+func (s *Server) WaitForPosts(n int, timeout time.Duration) []Post {
+	deadline := time.Now().Add(timeout)
+	for {
+		posts := s.Posts()
+		if len(posts) >= n || time.Now().After(deadline) {
+			return posts
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// RTMConnects returns the number of rtm.start and rtm.connect calls.
+func (s *Server) RTMConnects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rtmCalls
+}
+
+// SendEvent sends an event to all connected RTM clients.
+// If no clients are connected, the event is sent
+// to the next client that connects.
+func (s *Server) SendEvent(event map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.conns) == 0 {
+		s.pending = append(s.pending, event)
+		return
+	}
+	for c := range s.conns {
+		c.send(event)
+	}
+}
+
+// SendMessage sends a message event from the given user to all RTM clients
+// and adds it to the channel history.
+// It returns the timestamp of the message.
+func (s *Server) SendMessage(channel, user, text string) string {
+	return s.SendReply(channel, user, "", text)
+}
+
+// SendReply is like SendMessage, but the message is a reply
+// in the thread with the given parent timestamp.
+// If threadTS is empty, the message is not a thread reply.
+func (s *Server) SendReply(channel, user, threadTS, text string) string {
+	m := slack.Message{
+		Type:     "message",
+		Channel:  channel,
+		User:     user,
+		Text:     text,
+		TS:       s.ts(),
+		ThreadTS: threadTS,
+	}
+	s.AddMessage(m)
+	s.SendEvent(event(m))
+	return m.TS
+}
+
+// SendTopic sends a channel_topic message event
+// for a topic change by the given user to all RTM clients.
+func (s *Server) SendTopic(channel, user, topic string) {
+	s.SendEvent(map[string]interface{}{
+		"type":    "message",
+		"subtype": "channel_topic",
+		"channel": channel,
+		"user":    user,
+		"topic":   topic,
+		"text":    "<@" + user + "> set the channel topic: " + topic,
+		"ts":      s.ts(),
+	})
+}
+
+// Goodbye sends a goodbye event to all connected RTM clients.
+// Events sent after Goodbye are sent to the clients' new connections.
+func (s *Server) Goodbye() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.send(map[string]interface{}{"type": "goodbye"})
+		delete(s.conns, c)
+	}
+}
+
+// Disconnect closes all RTM connections.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.ws.Close()
+		delete(s.conns, c)
+	}
+}
+
+// ts returns a new, unique message timestamp.
+func (s *Server) ts() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextTS++
+	return strconv.FormatInt(time.Now().Unix(), 10) + "." + strconv.Itoa(100000+s.nextTS)
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	s.mu.Lock()
+	f, ok := s.methods[method]
+	s.mu.Unlock()
+
+	var resp interface{}
+	switch {
+	case s.Token != "" && r.Form.Get("token") != s.Token:
+		resp = errorResponse("invalid_auth")
+	case !ok:
+		resp = errorResponse("unknown_method")
+	default:
+		resp = f(r.Form)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// event returns v as an RTM event.
+func event(v interface{}) map[string]interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		panic(err)
+	}
+	return ev
+}
+
+func errorResponse(err string) map[string]interface{} {
+	return map[string]interface{}{"ok": false, "error": err}
+}
+
+func (s *Server) serveRTM(ws *websocket.Conn) {
+	c := &rtmConn{ws: ws}
+	wsURL := "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/rtm"
+	s.mu.Lock()
+	c.send(map[string]interface{}{"type": "hello"})
+	c.send(map[string]interface{}{"type": "reconnect_url", "url": wsURL})
+	for _, event := range s.pending {
+		c.send(event)
+	}
+	s.pending = nil
+	s.conns[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+	for {
+		var msg map[string]interface{}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		id := msg["id"]
+		switch t, _ := msg["type"].(string); t {
+		case "ping":
+			c.send(map[string]interface{}{"type": "pong", "reply_to": id})
+		case "message":
+			text, _ := msg["text"].(string)
+			c.send(map[string]interface{}{
+				"ok":       true,
+				"reply_to": id,
+				"ts":       s.ts(),
+				"text":     text,
+			})
+		default:
+			c.send(map[string]interface{}{
+				"ok":       false,
+				"reply_to": id,
+				"error":    map[string]interface{}{"code": 3, "msg": "unsupported type"},
+			})
+		}
+	}
+}
+
+func (s *Server) rtmStart(form map[string][]string) interface{} {
+	s.mu.Lock()
+	s.rtmCalls++
+	s.mu.Unlock()
+	return map[string]interface{}{
+		"ok":   true,
+		"url":  "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/rtm",
+		"self": map[string]interface{}{"id": s.SelfID},
+	}
+}
+
+// page returns the items of a page of n items
+// given the limit and cursor of a request,
+// and the next cursor.
+func page(form map[string][]string, n int) (start, end int, next string) {
+	start, _ = strconv.Atoi(get(form, "cursor"))
+	limit, err := strconv.Atoi(get(form, "limit"))
+	if err != nil || limit <= 0 {
+		limit = n
+	}
+	if start > n {
+		start = n
+	}
+	end = start + limit
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, strconv.Itoa(end)
+}
+
+func get(form map[string][]string, key string) string {
+	if vs := form[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func metadata(next string) map[string]interface{} {
+	return map[string]interface{}{"next_cursor": next}
+}
+
+func (s *Server) usersInfo(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := get(form, "user")
+	for _, u := range s.users {
+		if u.ID == id {
+			return map[string]interface{}{"ok": true, "user": u}
+		}
+	}
+	return errorResponse("user_not_found")
+}
+
+func (s *Server) usersList(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start, end, next := page(form, len(s.users))
+	return map[string]interface{}{
+		"ok":                true,
+		"members":           s.users[start:end],
+		"response_metadata": metadata(next),
+	}
+}
+
+func (s *Server) userGroupsList(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{"ok": true, "usergroups": s.groups}
+}
+
+func conversationType(c slack.Conversation) string {
+	switch {
+	case c.IsIM:
+		return slack.IM
+	case c.IsMPIM:
+		return slack.MPIM
+	case c.IsPrivate:
+		return slack.PrivateChannel
+	default:
+		return slack.PublicChannel
+	}
+}
+
+func (s *Server) conversationsList(form map[string][]string) interface{} {
+	types := map[string]bool{slack.PublicChannel: true}
+	if t := get(form, "types"); t != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(t, ",") {
+			types[t] = true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var convs []slack.Conversation
+	for _, c := range s.convs {
+		if types[conversationType(c)] {
+			convs = append(convs, c)
+		}
+	}
+	start, end, next := page(form, len(convs))
+	return map[string]interface{}{
+		"ok":                true,
+		"channels":          convs[start:end],
+		"response_metadata": metadata(next),
+	}
+}
+
+// conversation returns a pointer to the conversation with the given ID.
+// The caller must hold s.mu.
+func (s *Server) conversation(id string) *slack.Conversation {
+	for i := range s.convs {
+		if s.convs[i].ID == id {
+			return &s.convs[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) conversationsInfo(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.conversation(get(form, "channel"))
+	if c == nil {
+		return errorResponse("channel_not_found")
+	}
+	return map[string]interface{}{"ok": true, "channel": c}
+}
+
+func (s *Server) conversationsJoin(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.conversation(get(form, "channel"))
+	if c == nil {
+		return errorResponse("channel_not_found")
+	}
+	if c.IsPrivate {
+		return errorResponse("method_not_supported_for_channel_type")
+	}
+	if !c.IsMember {
+		c.IsMember = true
+		s.members[c.ID] = append(s.members[c.ID], s.SelfID)
+	}
+	return map[string]interface{}{"ok": true, "channel": c}
+}
+
+func (s *Server) conversationsSetTopic(form map[string][]string) interface{} {
+	id, topic := get(form, "channel"), get(form, "topic")
+	s.mu.Lock()
+	c := s.conversation(id)
+	if c == nil {
+		s.mu.Unlock()
+		return errorResponse("channel_not_found")
+	}
+	c.Topic = slack.Topic{Value: topic, Creator: s.SelfID, LastSet: time.Now().Unix()}
+	conv := *c
+	s.mu.Unlock()
+	s.SendTopic(id, s.SelfID, topic)
+	return map[string]interface{}{"ok": true, "channel": conv}
+}
+
+func (s *Server) conversationsMembers(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := get(form, "channel")
+	if s.conversation(id) == nil {
+		return errorResponse("channel_not_found")
+	}
+	members := s.members[id]
+	start, end, next := page(form, len(members))
+	return map[string]interface{}{
+		"ok":                true,
+		"members":           members[start:end],
+		"response_metadata": metadata(next),
+	}
+}
+
+func (s *Server) conversationsHistory(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := get(form, "channel")
+	if s.conversation(id) == nil {
+		return errorResponse("channel_not_found")
+	}
+	// History is most recent first.
+	hist := s.history[id]
+	msgs := make([]slack.Message, len(hist))
+	for i, m := range hist {
+		msgs[len(hist)-1-i] = m
+	}
+	start, end, next := page(form, len(msgs))
+	return map[string]interface{}{
+		"ok":                true,
+		"messages":          msgs[start:end],
+		"has_more":          next != "",
+		"response_metadata": metadata(next),
+	}
+}
+
+func (s *Server) conversationsReplies(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ts := get(form, "channel"), get(form, "ts")
+	if s.conversation(id) == nil {
+		return errorResponse("channel_not_found")
+	}
+	var msgs []slack.Message
+	for _, m := range s.history[id] {
+		if m.TS == ts || m.ThreadTS == ts {
+			msgs = append(msgs, m)
+		}
+	}
+	if len(msgs) == 0 {
+		return errorResponse("thread_not_found")
+	}
+	start, end, next := page(form, len(msgs))
+	return map[string]interface{}{
+		"ok":                true,
+		"messages":          msgs[start:end],
+		"has_more":          next != "",
+		"response_metadata": metadata(next),
+	}
+}
+
+func (s *Server) chatPostMessage(form map[string][]string) interface{} {
+	channel := get(form, "channel")
+	s.mu.Lock()
+	ok := s.conversation(channel) != nil
+	s.mu.Unlock()
+	if !ok {
+		return errorResponse("channel_not_found")
+	}
+	if get(form, "text") == "" {
+		return errorResponse("no_text")
+	}
+	p := Post{
+		Channel:  channel,
+		Username: get(form, "username"),
+		IconURL:  get(form, "icon_url"),
+		Text:     get(form, "text"),
+		ThreadTS: get(form, "thread_ts"),
+		TS:       s.ts(),
+		Form:     form,
+	}
+	msg := slack.Message{
+		Type:     "message",
+		Subtype:  "bot_message",
+		Channel:  p.Channel,
+		BotID:    s.BotID,
+		Username: p.Username,
+		Text:     p.Text,
+		TS:       p.TS,
+		ThreadTS: p.ThreadTS,
+	}
+	s.mu.Lock()
+	s.posts = append(s.posts, p)
+	s.history[channel] = append(s.history[channel], msg)
+	s.mu.Unlock()
+	s.SendEvent(event(msg))
+	return map[string]interface{}{
+		"ok":      true,
+		"channel": p.Channel,
+		"ts":      p.TS,
+		"message": msg,
+	}
+}
+
+// post returns a pointer to the post with the given channel and timestamp.
+// The caller must hold s.mu.
+func (s *Server) post(channel, ts string) *Post {
+	for i := range s.posts {
+		if p := &s.posts[i]; p.Channel == channel && p.TS == ts {
+			return p
+		}
+	}
+	return nil
+}
+
+// removeMessage removes a message from the channel history
+// and returns it.
+// The caller must hold s.mu.
+func (s *Server) removeMessage(channel, ts string) (slack.Message, bool) {
+	hist := s.history[channel]
+	for i, m := range hist {
+		if m.TS == ts {
+			s.history[channel] = append(hist[:i:i], hist[i+1:]...)
+			return m, true
+		}
+	}
+	return slack.Message{}, false
+}
+
+func (s *Server) chatUpdate(form map[string][]string) interface{} {
+	channel, ts, text := get(form, "channel"), get(form, "ts"), get(form, "text")
+	s.mu.Lock()
+	p := s.post(channel, ts)
+	if p == nil || p.Deleted {
+		s.mu.Unlock()
+		return errorResponse("message_not_found")
+	}
+	p.Text = text
+	p.Edits = append(p.Edits, text)
+	var prev, msg slack.Message
+	for i, m := range s.history[channel] {
+		if m.TS == ts {
+			prev = m
+			s.history[channel][i].Text = text
+			msg = s.history[channel][i]
+		}
+	}
+	s.mu.Unlock()
+	s.SendEvent(map[string]interface{}{
+		"type":             "message",
+		"subtype":          "message_changed",
+		"channel":          channel,
+		"ts":               s.ts(),
+		"message":          event(msg),
+		"previous_message": event(prev),
+	})
+	return map[string]interface{}{"ok": true, "channel": channel, "ts": ts, "text": text}
+}
+
+func (s *Server) chatDelete(form map[string][]string) interface{} {
+	channel, ts := get(form, "channel"), get(form, "ts")
+	s.mu.Lock()
+	p := s.post(channel, ts)
+	if p == nil || p.Deleted {
+		s.mu.Unlock()
+		return errorResponse("message_not_found")
+	}
+	p.Deleted = true
+	prev, _ := s.removeMessage(channel, ts)
+	s.mu.Unlock()
+	s.SendEvent(map[string]interface{}{
+		"type":             "message",
+		"subtype":          "message_deleted",
+		"channel":          channel,
+		"ts":               s.ts(),
+		"deleted_ts":       ts,
+		"previous_message": event(prev),
+	})
+	return map[string]interface{}{"ok": true, "channel": channel, "ts": ts}
+}
+
+func (s *Server) filesInfo(form map[string][]string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[get(form, "file")]
+	if !ok {
+		return errorResponse("file_not_found")
+	}
+	return map[string]interface{}{"ok": true, "file": f.File}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	f, ok := s.files[strings.TrimPrefix(r.URL.Path, "/files/")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", f.Mimetype)
+	w.Write(f.content)
+}
@@ -0,0 +1,25 @@
+package slack
+
+// A UserGroup object describes a slack user group.
+type UserGroup struct {
+	ID string `json:"id"`
+	// Handle is the mention handle without a leading @.
+	Handle      string `json:"handle"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// UserGroupsList returns a list of all slack user groups.
+func (c *Client) UserGroupsList() ([]UserGroup, error) {
+	var resp struct {
+		Response
+		UserGroups []UserGroup `json:"usergroups"`
+	}
+	if err := c.do(&resp, "usergroups.list"); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, ResponseError{resp.Response}
+	}
+	return resp.UserGroups, nil
+}
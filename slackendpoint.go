@@ -0,0 +1,567 @@
+package main
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velour/relay/bridge"
+	"github.com/velour/relay/emoji"
+	"github.com/velour/relay/slack"
+)
+
+const serverIcon = "https://raw.githubusercontent.com/velour/relay/master/resource/servericon.png"
+
+// A slackEndpoint is a bridge's slack channel.
+type slackEndpoint struct {
+	workspace *workspace
+	cfg       *bridgeConfig
+	threads   *threads
+	// bursts are the posts of coalesced IRC messages,
+	// and the post to which IRC messages are added if the bridge updates posts.
+	bursts    *bursts
+	files     *fileServer
+	reactions *reactions
+	// inbox holds the slack events routed to the endpoint
+	// until they are handled.
+	inbox  *inbox
+	events chan bridge.Event
+	// done is closed when the bridge stops reading events.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// c, dir, userID, and channelID are set
+	// when the endpoint joins the workspace.
+	c         *slack.Client
+	dir       *slack.Directory
+	userID    string
+	channelID string
+}
+
+// newSlackEndpoint returns the endpoint of the bridge's slack channel
+// on workspace w.
+// If files is non-nil, it re-hosts files shared in the channel.
+func newSlackEndpoint(w *workspace, cfg *bridgeConfig, files *fileServer) *slackEndpoint {
+	events := make(chan bridge.Event)
+	done := make(chan struct{})
+	ep := &slackEndpoint{
+		workspace: w,
+		cfg:       cfg,
+		threads:   newThreads(),
+		files:     files,
+		bursts:    newBursts(cfg.Coalesce.Duration),
+		reactions: newReactions(events),
+		inbox:     newInbox(cfg.Buffer),
+		events:    events,
+		done:      done,
+	}
+	ep.reactions.done = done
+	return ep
+}
+
+func (ep *slackEndpoint) String() string {
+	return "slack " + ep.workspace.name + " " + ep.cfg.SlackChannel
+}
+
+// Connect joins the slack channel.
+func (ep *slackEndpoint) Connect() error {
+	_, err := ep.workspace.join(ep.cfg.SlackChannel, ep)
+	return err
+}
+
+// Events returns the events of the slack channel.
+func (ep *slackEndpoint) Events() <-chan bridge.Event {
+	return ep.events
+}
+
+// Capabilities returns that slack can edit, delete, and reply to the relay's posts,
+// and set topics.
+// If the bridge coalesces messages without updating posts,
+// the bridge waits for more messages from a sender to post together.
+func (ep *slackEndpoint) Capabilities() bridge.Capabilities {
+	caps := bridge.Capabilities{Edit: true, Delete: true, Topic: true, Reply: true}
+	if !ep.updatesPosts() {
+		caps.Coalesce = ep.cfg.Coalesce.Duration
+	}
+	return caps
+}
+
+// updatesPosts returns whether the bridge coalesces messages by updating posts.
+func (ep *slackEndpoint) updatesPosts() bool {
+	return ep.cfg.UpdatePosts && ep.cfg.Coalesce.Duration > 0
+}
+
+// Close stops routing the slack channel's events to the endpoint.
+func (ep *slackEndpoint) Close() error {
+	ep.closeOnce.Do(func() {
+		close(ep.done)
+		ep.workspace.leave(ep)
+	})
+	return nil
+}
+
+// Send posts an event to the slack channel.
+// If the bridge coalesces messages by updating posts,
+// a message is added to the last post if it is from the same sender.
+// Corrections of a message in a post of coalesced messages update the post.
+func (ep *slackEndpoint) Send(e bridge.Event) (string, error) {
+	switch e.Kind {
+	case bridge.Edit, bridge.Delete:
+		if b, ok := ep.bursts.correct(e.Target.ID, e.Target.Text, e.Text); ok {
+			return ep.updateBurst(b)
+		}
+	}
+	switch e.Kind {
+	case bridge.Edit:
+		text := ep.fromIRC(e.Text)
+		if err := ep.c.ChatUpdate(ep.channelID, e.Target.ID, text); err != nil {
+			return "", unavailable(err)
+		}
+		ep.threads.update(e.Target.ID, text)
+		return e.Target.ID, nil
+	case bridge.Delete:
+		return "", unavailable(ep.c.ChatDelete(ep.channelID, e.Target.ID))
+	case bridge.Topic:
+		if _, err := ep.c.ConversationsSetTopic(ep.channelID, e.Text); err != nil {
+			return "", unavailable(err)
+		}
+	}
+	p := ep.render(e)
+	if ep.updatesPosts() {
+		if b, ok := ep.bursts.extend(e, p.ThreadTS); ok {
+			return ep.updateBurst(b)
+		}
+	}
+	ts, err := ep.c.Post(p)
+	if err != nil {
+		return "", unavailable(err)
+	}
+	ep.threads.add(ts, p.ThreadTS, p.Username, p.Text)
+	ep.threads.relayed(ts)
+	ep.bursts.posted(ts, p.ThreadTS, e)
+	return ts, nil
+}
+
+// updateBurst updates a post of coalesced messages,
+// or deletes it if no messages remain.
+// It returns the timestamp of the post.
+func (ep *slackEndpoint) updateBurst(b burst) (string, error) {
+	if b.e.Text == "" {
+		if err := ep.c.ChatDelete(ep.channelID, b.ts); err != nil {
+			return "", unavailable(err)
+		}
+		ep.bursts.interrupt()
+		return "", nil
+	}
+	text := ep.render(b.e).Text
+	if err := ep.c.ChatUpdate(ep.channelID, b.ts, text); err != nil {
+		return "", unavailable(err)
+	}
+	ep.bursts.update(b)
+	ep.threads.update(b.ts, text)
+	return b.ts, nil
+}
+
+// unavailable returns err as a bridge.UnavailableError
+// if it may succeed when retried:
+// if slack could not be reached, or if the call was rate limited.
+// Other errors from slack are returned as is.
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	if r, ok := err.(slack.ResponseError); ok && r.Response.Error != "ratelimited" {
+		return err
+	}
+	return bridge.UnavailableError{Err: err}
+}
+
+// render returns the slack post of an event.
+// Messages and actions are posted as their sender,
+// with replies in the thread of the message they reply to;
+// other events are posted as notices from their network.
+func (ep *slackEndpoint) render(e bridge.Event) slack.PostParams {
+	p := slack.PostParams{Channel: ep.channelID}
+	if e.Kind != bridge.Message && e.Kind != bridge.Action {
+		p.Text = bridge.Render(e)
+		p.Username, p.IconURL = e.Network, serverIcon
+		if ep.cfg.Blocks && e.From != "" && strings.HasPrefix(p.Text, e.From) {
+			p.Blocks = noticeBlocks(e.From, p.Text)
+		}
+		return p
+	}
+	text := e.Text
+	if e.ReplyTo == nil || e.ReplyTo.ID == "" {
+		text = e.Body()
+	}
+	text = ep.fromIRC(text)
+	if e.Kind == bridge.Action && text != "" {
+		text = "_" + text + "_"
+	}
+	for _, a := range e.Attachments {
+		text += "\n<" + a.URL + "|" + slack.Escape(a.Name) + ">"
+	}
+	p.Text = strings.TrimPrefix(text, "\n")
+	p.Username, p.IconURL = e.From, userIcon(e.From)
+	switch {
+	case e.ReplyTo != nil && e.ReplyTo.ID != "":
+		p.ThreadTS = e.ReplyTo.ID
+	case ep.cfg.Threads:
+		p.ThreadTS = ep.threads.route(p.Text)
+	}
+	return p
+}
+
+// fromIRC returns the text of an IRC message for slack.
+func (ep *slackEndpoint) fromIRC(text string) string {
+	if ep.cfg.ASCIIEmoji {
+		return emoji.FromASCII(text)
+	}
+	return text
+}
+
+// userIcon returns the icon of an IRC nick.
+func userIcon(who string) string {
+	var h int
+	for _, r := range who {
+		h = int(r) + 31*h
+	}
+	return icons[h%len(icons)]
+}
+
+// noticeBlocks returns the blocks rendering a notice
+// as grey context text with the actor's nick in bold.
+func noticeBlocks(actor, text string) []slack.Block {
+	rest := slack.Escape(strings.TrimPrefix(text, actor))
+	text = "*" + slack.Escape(actor) + "*" + rest
+	return []slack.Block{slack.NewContext(slack.Markdown(text))}
+}
+
+// send reports an event, unless the bridge has stopped.
+func (ep *slackEndpoint) send(e bridge.Event) {
+	select {
+	case ep.events <- e:
+	case <-ep.done:
+	}
+}
+
+// run handles the events in the endpoint's inbox
+// until the workspace's connection fails or the endpoint is closed,
+// and then closes the events channel.
+func (ep *slackEndpoint) run() {
+	for {
+		event, dropped, ok := ep.inbox.take(ep.done)
+		if dropped > 0 {
+			log.Printf("%v dropped %d slack events", ep, dropped)
+		}
+		if !ok {
+			break
+		}
+		ep.handle(event)
+	}
+	ep.reactions.close()
+	close(ep.events)
+}
+
+// handle handles an event in the endpoint's channel.
+func (ep *slackEndpoint) handle(event map[string]interface{}) {
+	switch t, _ := event["type"].(string); t {
+	case "message":
+		ep.message(event)
+	case "reaction_added", "reaction_removed":
+		ep.reaction(event)
+	}
+}
+
+// disconnect closes the inbox when the workspace's connection fails,
+// so that the events channel is closed once the inbox's events are handled.
+// Pending reactions are dropped.
+func (ep *slackEndpoint) disconnect() {
+	ep.inbox.close()
+}
+
+// message handles a message event.
+func (ep *slackEndpoint) message(event map[string]interface{}) {
+	var ev struct {
+		slack.Message
+		Edited    slack.Message `json:"message"`
+		Previous  slack.Message `json:"previous_message"`
+		DeletedTS string        `json:"deleted_ts"`
+		Topic     string        `json:"topic"`
+	}
+	if err := slack.DecodeEvent(event, &ev); err != nil {
+		log.Println("slack failed to decode message:", err)
+		return
+	}
+	if ev.Channel != ep.channelID {
+		return
+	}
+	switch ev.Subtype {
+	case "", "file_share", "me_message", "bot_message":
+		ep.say(ev.Message)
+	case "channel_topic", "group_topic":
+		if ev.User == ep.c.ID() {
+			break
+		}
+		who, _ := ep.who(ev.User)
+		ep.send(bridge.Event{Kind: bridge.Topic, Network: ep.workspace.name, Time: ev.Time(), From: who, UserID: ev.User, Text: ep.text(ev.Topic)})
+	case "message_changed":
+		ep.edit(ev.Edited, ev.Previous)
+	case "message_deleted":
+		if ev.Previous.TS == "" {
+			ev.Previous.TS = ev.DeletedTS
+		}
+		ep.delete(ev.Previous)
+	}
+}
+
+// who returns the display name of a slack user
+// and the names by which they may be addressed.
+func (ep *slackEndpoint) who(userID string) (string, []string) {
+	u, err := ep.dir.FetchUser(ep.c, userID)
+	if err != nil {
+		log.Println("slack failed to get user:", err)
+		return userID, nil
+	}
+	return u.DisplayName(), []string{u.Name, u.DisplayName()}
+}
+
+// relays returns whether messages by the sender of m are relayed.
+// In shared mode, these are all allowed users and bots;
+// otherwise, they are the workspace's nick and allowed bots.
+func (ep *slackEndpoint) relays(m slack.Message) bool {
+	if m.Subtype == "bot_message" {
+		return ep.cfg.Bots && !ep.c.PostedBySelf(m) && ep.allowed(m.BotID, m.Username)
+	}
+	if m.User == "" {
+		return false
+	}
+	if m.User == ep.userID && !ep.cfg.Shared {
+		return true
+	}
+	_, names := ep.who(m.User)
+	return ep.cfg.Shared && ep.allowed(append(names, m.User)...)
+}
+
+// allowed returns whether a sender with the given names and IDs
+// is allowed by the allow and deny lists.
+func (ep *slackEndpoint) allowed(names ...string) bool {
+	matches := func(list []string) bool {
+		for _, l := range list {
+			for _, n := range names {
+				if n != "" && strings.EqualFold(strings.TrimPrefix(l, "@"), n) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if matches(ep.cfg.Deny) {
+		return false
+	}
+	return len(ep.cfg.Allow) == 0 || matches(ep.cfg.Allow)
+}
+
+// self returns whether m is sent by the user whom the relay speaks for.
+// Only the workspace's nick is, and only if not in shared mode.
+func (ep *slackEndpoint) self(m slack.Message) bool {
+	return !ep.cfg.Shared && m.User == ep.userID && m.Subtype != "bot_message"
+}
+
+// text returns slack message text decoded for IRC.
+func (ep *slackEndpoint) text(text string) string {
+	return emoji.Emojize(ep.dir.Decode(text))
+}
+
+// say reports a new message.
+func (ep *slackEndpoint) say(m slack.Message) {
+	bot := m.Subtype == "bot_message"
+	if bot && ep.c.PostedBySelf(m) {
+		return
+	}
+	if !m.IsReply() {
+		// Don't add to a post above someone else's.
+		ep.bursts.interrupt()
+	}
+	text := ep.text(m.FallbackText())
+	var who string
+	var names []string
+	if bot {
+		who = botName(m)
+	} else {
+		who, names = ep.who(m.User)
+	}
+	var threadTS string
+	if m.IsReply() {
+		threadTS = m.ThreadTS
+	}
+	ep.threads.add(m.TS, threadTS, who, text, names...)
+	if !ep.relays(m) {
+		return
+	}
+	e := bridge.Event{
+		Kind:    bridge.Message,
+		ID:      m.TS,
+		Network: ep.workspace.name,
+		Time:    m.Time(),
+		From:    who,
+		UserID:  m.User,
+		Bot:     bot,
+		Self:    ep.self(m),
+		Text:    text,
+	}
+	if m.Subtype == "me_message" {
+		e.Kind = bridge.Action
+	}
+	if bot {
+		e.UserID = m.BotID
+	} else if u, ok := ep.dir.User(m.User); ok {
+		e.User = u.Name
+	}
+	if m.IsReply() {
+		e.ReplyTo = ep.parent(m)
+	}
+	for _, f := range m.Files {
+		if a, ok := ep.file(f); ok {
+			e.Attachments = append(e.Attachments, a)
+		}
+	}
+	if e.Text == "" && len(e.Attachments) == 0 {
+		return
+	}
+	log.Printf("slack sending message\n%#v\n\n", m)
+	ep.send(e)
+	ep.threads.relayed(m.TS)
+}
+
+// botName returns the name to attribute a bot message to.
+func botName(m slack.Message) string {
+	if m.Username != "" {
+		return m.Username
+	}
+	return m.BotID
+}
+
+// file returns the attachment of a shared file.
+func (ep *slackEndpoint) file(f slack.File) (bridge.Attachment, bool) {
+	if ep.cfg.Files == "none" {
+		return bridge.Attachment{}, false
+	}
+	if f.FileAccess == "check_file_info" || f.Permalink == "" {
+		info, err := ep.c.FilesInfo(f.ID)
+		if err != nil {
+			log.Println("slack failed to get file info:", err)
+			return bridge.Attachment{}, false
+		}
+		f = info
+	}
+	url := f.Permalink
+	if f.PermalinkPublic != "" {
+		url = f.PermalinkPublic
+	}
+	if ep.files != nil && !f.IsExternal && f.URLPrivate != "" && f.Size <= maxFileSize {
+		u, err := ep.files.add(f.Name, f.Mimetype, url, func(w io.Writer) error {
+			return ep.c.Download(f.URLPrivate, w, maxFileSize)
+		})
+		if err != nil {
+			log.Println("failed to re-host slack file:", err)
+		} else {
+			url = u
+		}
+	}
+	name := f.Name
+	if name == "" {
+		name = f.Title
+	}
+	return bridge.Attachment{Name: name, MimeType: f.Mimetype, Size: f.Size, URL: url}, true
+}
+
+// reaction handles a reaction_added or reaction_removed event.
+func (ep *slackEndpoint) reaction(event map[string]interface{}) {
+	if !ep.cfg.Reactions {
+		return
+	}
+	var ev struct {
+		Type     string `json:"type"`
+		User     string `json:"user"`
+		Reaction string `json:"reaction"`
+		Item     struct {
+			Type    string `json:"type"`
+			Channel string `json:"channel"`
+			TS      string `json:"ts"`
+		} `json:"item"`
+	}
+	if err := slack.DecodeEvent(event, &ev); err != nil {
+		log.Println("slack failed to decode reaction:", err)
+		return
+	}
+	if ev.Item.Type != "message" || ev.Item.Channel != ep.channelID {
+		return
+	}
+	target, ok := ep.threads.get(ev.Item.TS)
+	if !ok || !target.relayed {
+		return
+	}
+	who, _ := ep.who(ev.User)
+	removed := ev.Type == "reaction_removed"
+	ep.reactions.add(ev.Item.TS, target, who, emoji.Emojize(":"+ev.Reaction+":"), removed)
+}
+
+// edit reports an edited message.
+func (ep *slackEndpoint) edit(m, prev slack.Message) {
+	if m.Subtype == "bot_message" || !ep.relays(m) || m.Text == prev.Text {
+		// Unfurling links also changes a message,
+		// but leaves the text the same.
+		return
+	}
+	text := ep.text(m.Text)
+	who, _ := ep.who(m.User)
+	ep.threads.update(m.TS, text)
+	ep.send(bridge.Event{
+		Kind:    bridge.Edit,
+		Network: ep.workspace.name,
+		Time:    time.Now(),
+		From:    who,
+		UserID:  m.User,
+		Target:  bridge.Ref{ID: m.TS, From: who, Text: ep.text(prev.Text)},
+		Text:    text,
+	})
+}
+
+// delete reports a deleted message, if the bridge relays deletions.
+func (ep *slackEndpoint) delete(prev slack.Message) {
+	if !ep.cfg.Deletes || prev.Subtype == "bot_message" || !ep.relays(prev) {
+		return
+	}
+	who, _ := ep.who(prev.User)
+	ep.send(bridge.Event{
+		Kind:    bridge.Delete,
+		Network: ep.workspace.name,
+		Time:    time.Now(),
+		From:    who,
+		UserID:  prev.User,
+		Target:  bridge.Ref{ID: prev.TS, From: who, Text: ep.text(prev.Text)},
+	})
+}
+
+// parent returns a reference to the parent of a thread reply.
+func (ep *slackEndpoint) parent(m slack.Message) *bridge.Ref {
+	if p, ok := ep.threads.get(m.ThreadTS); ok {
+		return &bridge.Ref{ID: m.ThreadTS, From: p.who, Text: p.text}
+	}
+	msgs, err := ep.c.ConversationsReplies(m.Channel, m.ThreadTS)
+	if err != nil || len(msgs) == 0 {
+		log.Println("slack failed to get thread parent:", err)
+		return &bridge.Ref{ID: m.ThreadTS}
+	}
+	p := msgs[0]
+	who := p.Username
+	if p.User != "" {
+		who, _ = ep.who(p.User)
+	}
+	text := ep.text(p.Text)
+	ep.threads.add(p.TS, "", who, text)
+	return &bridge.Ref{ID: m.ThreadTS, From: who, Text: text}
+}
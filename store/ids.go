@@ -0,0 +1,91 @@
+package store
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var idsBucket = []byte("ids")
+
+// IDs maps the IDs of messages relayed between endpoints.
+// A mapping expires after its time to live.
+// IDs implements bridge.IDStore.
+type IDs struct {
+	db  *bolt.DB
+	ttl time.Duration
+	// now returns the current time.
+	now func() time.Time
+}
+
+// IDs returns the database's ID mappings,
+// which expire after ttl.
+func (db *DB) IDs(ttl time.Duration) (*IDs, error) {
+	if err := db.bucket(idsBucket); err != nil {
+		return nil, err
+	}
+	return &IDs{db: db.db, ttl: ttl, now: time.Now}, nil
+}
+
+// Put records that the message with the given key
+// was relayed as, or from, the message with ID id.
+func (s *IDs) Put(key, id string) error {
+	v := make([]byte, 8+len(id))
+	binary.BigEndian.PutUint64(v, uint64(s.now().Unix()))
+	copy(v[8:], id)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idsBucket).Put([]byte(key), v)
+	})
+}
+
+// Get returns the ID recorded for the message with the given key,
+// and whether there is one that has not expired.
+func (s *IDs) Get(key string) (string, bool, error) {
+	var id string
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(idsBucket).Get([]byte(key))
+		if len(v) < 8 || s.expired(v) {
+			return nil
+		}
+		id, ok = string(v[8:]), true
+		return nil
+	})
+	return id, ok, err
+}
+
+// Prune deletes the expired mappings
+// and returns the number deleted.
+func (s *IDs) Prune() (int, error) {
+	var n int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(idsBucket)
+		// Deleting while iterating with a cursor skips keys,
+		// so collect the expired keys first.
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if len(v) < 8 || s.expired(v) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+// expired returns whether the mapping with value v has expired.
+func (s *IDs) expired(v []byte) bool {
+	t := time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
+	return s.now().Sub(t) > s.ttl
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	ids, err := db.IDs(time.Hour)
+	if err != nil {
+		t.Fatalf("IDs failed: %v", err)
+	}
+	now := time.Now()
+	ids.now = func() time.Time { return now }
+	if err := ids.Put("old", "1.1"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	now = now.Add(30 * time.Minute)
+	if err := ids.Put("new", "1.2"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if id, ok, err := ids.Get("old"); err != nil || !ok || id != "1.1" {
+		t.Errorf("Get(old)=%q, %v, %v, want 1.1, true, nil", id, ok, err)
+	}
+	if _, ok, err := ids.Get("missing"); err != nil || ok {
+		t.Errorf("Get(missing)=_, %v, %v, want false, nil", ok, err)
+	}
+
+	now = now.Add(45 * time.Minute)
+	if _, ok, _ := ids.Get("old"); ok {
+		t.Errorf("Get(old) found an expired ID")
+	}
+	if n, err := ids.Prune(); err != nil || n != 1 {
+		t.Errorf("Prune()=%d, %v, want 1, nil", n, err)
+	}
+
+	// IDs survive reopening the file.
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	if ids, err = db.IDs(time.Hour); err != nil {
+		t.Fatalf("IDs failed: %v", err)
+	}
+	ids.now = func() time.Time { return now }
+	if id, ok, err := ids.Get("new"); err != nil || !ok || id != "1.2" {
+		t.Errorf("Get(new)=%q, %v, %v after reopening, want 1.2, true, nil", id, ok, err)
+	}
+}
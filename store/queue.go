@@ -0,0 +1,114 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/velour/relay/bridge"
+	bolt "go.etcd.io/bbolt"
+)
+
+var queuesBucket = []byte("queues")
+
+var (
+	_ bridge.IDStore    = (*IDs)(nil)
+	_ bridge.QueueStore = (*DB)(nil)
+)
+
+// A Queue is a queue of events awaiting delivery,
+// kept in a bucket of the queues bucket.
+// Its events are keyed by their sequence number
+// and stored as JSON.
+type Queue struct {
+	db   *bolt.DB
+	name []byte
+	// n is the number of events in the queue.
+	n int
+}
+
+// Queue returns the named event queue,
+// creating it if it does not exist.
+// DB implements bridge.QueueStore.
+func (db *DB) Queue(name string) (bridge.Queue, error) {
+	q := &Queue{db: db.db, name: []byte(name)}
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		qs, err := tx.CreateBucketIfNotExists(queuesBucket)
+		if err != nil {
+			return err
+		}
+		b, err := qs.CreateBucketIfNotExists(q.name)
+		if err != nil {
+			return err
+		}
+		q.n = b.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Push adds an event to the back of the queue.
+func (q *Queue) Push(e bridge.Event) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = q.db.Update(func(tx *bolt.Tx) error {
+		b := q.bucket(tx)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, seq)
+		return b.Put(k, v)
+	})
+	if err == nil {
+		q.n++
+	}
+	return err
+}
+
+// Peek returns the event at the front of the queue,
+// and whether there is one.
+func (q *Queue) Peek() (bridge.Event, bool, error) {
+	var e bridge.Event
+	var ok bool
+	err := q.db.View(func(tx *bolt.Tx) error {
+		k, v := q.bucket(tx).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &e)
+	})
+	return e, ok, err
+}
+
+// Pop removes the event at the front of the queue.
+func (q *Queue) Pop() error {
+	var popped bool
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		c := q.bucket(tx).Cursor()
+		if k, _ := c.First(); k == nil {
+			return nil
+		}
+		popped = true
+		return c.Delete()
+	})
+	if err == nil && popped {
+		q.n--
+	}
+	return err
+}
+
+// Len returns the number of events in the queue.
+func (q *Queue) Len() int {
+	return q.n
+}
+
+func (q *Queue) bucket(tx *bolt.Tx) *bolt.Bucket {
+	return tx.Bucket(queuesBucket).Bucket(q.name)
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/velour/relay/bridge"
+)
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	q, err := db.Queue("go\x00b")
+	if err != nil {
+		t.Fatalf("Queue failed: %v", err)
+	}
+	if _, ok, err := q.Peek(); err != nil || ok {
+		t.Errorf("Peek() of an empty queue=_, %v, %v, want false, nil", ok, err)
+	}
+	now := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+	events := []bridge.Event{
+		{Kind: bridge.Message, ID: "1", Time: now, From: "alice", Text: "hi", ReplyTo: &bridge.Ref{ID: "0", Text: "hello"}},
+		{Kind: bridge.Kick, Time: now, From: "bob", Subject: "carol"},
+		{Kind: bridge.Message, ID: "2", Time: now, Attachments: []bridge.Attachment{{Name: "a.png", Size: 2048, URL: "https://example.com/a.png"}}},
+	}
+	for _, e := range events {
+		if err := q.Push(e); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	if err := q.Pop(); err != nil {
+		t.Fatalf("Pop failed: %v", err)
+	}
+
+	// Queues survive reopening the file.
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	if q, err = db.Queue("go\x00b"); err != nil {
+		t.Fatalf("Queue failed: %v", err)
+	}
+	if n := q.Len(); n != 2 {
+		t.Errorf("Len()=%d after reopening, want 2", n)
+	}
+	for _, want := range events[1:] {
+		e, ok, err := q.Peek()
+		if err != nil || !ok {
+			t.Fatalf("Peek()=_, %v, %v, want true, nil", ok, err)
+		}
+		if !reflect.DeepEqual(e, want) {
+			t.Errorf("Peek()=%+v, want %+v", e, want)
+		}
+		if err := q.Pop(); err != nil {
+			t.Fatalf("Pop failed: %v", err)
+		}
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("Len()=%d after popping all, want 0", n)
+	}
+}
@@ -0,0 +1,37 @@
+// Package store keeps the relay's state in an embedded database file,
+// so that it survives restarts.
+package store
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A DB is an open database file.
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens the database file at path, creating it if needed.
+// It fails if another process has the file open.
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database file.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// bucket creates the named bucket, if it does not exist.
+func (db *DB) bucket(name []byte) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(name)
+		return err
+	})
+}
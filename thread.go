@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecent is the number of recent slack messages remembered.
+	maxRecent = 1000
+
+	// threadWindow is how long after a slack user's last thread reply
+	// an IRC message addressed to them is routed into the thread.
+	threadWindow = 10 * time.Minute
+)
+
+// A recent is a recently seen slack message.
+type recent struct {
+	who  string
+	text string
+	// relayed is whether the message was relayed between IRC and slack.
+	relayed bool
+}
+
+// threads tracks recent slack messages
+// and the threads in which slack users are talking.
+// It is safe for concurrent use.
+type threads struct {
+	sync.Mutex
+	msgs  map[string]recent
+	order []string
+	// last maps a lowercase slack name to the user's latest thread reply.
+	last map[string]threadReply
+}
+
+type threadReply struct {
+	threadTS string
+	when     time.Time
+}
+
+func newThreads() *threads {
+	return &threads{msgs: make(map[string]recent), last: make(map[string]threadReply)}
+}
+
+// add records a slack message with the given timestamp.
+// If threadTS is non-empty, the message is a reply in that thread
+// and is attributed to each of the given names of its sender.
+func (t *threads) add(ts, threadTS, who, text string, names ...string) {
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.msgs[ts]; !ok {
+		t.order = append(t.order, ts)
+	}
+	m := t.msgs[ts]
+	m.who, m.text = who, text
+	t.msgs[ts] = m
+	for len(t.order) > maxRecent {
+		delete(t.msgs, t.order[0])
+		t.order = t.order[1:]
+	}
+	if threadTS == "" {
+		return
+	}
+	for _, n := range names {
+		if n != "" {
+			t.last[strings.ToLower(n)] = threadReply{threadTS: threadTS, when: time.Now()}
+		}
+	}
+}
+
+// update replaces the text of a recent message.
+func (t *threads) update(ts, text string) {
+	t.Lock()
+	defer t.Unlock()
+	if m, ok := t.msgs[ts]; ok {
+		m.text = text
+		t.msgs[ts] = m
+	}
+}
+
+// relayed marks a recent message as relayed between IRC and slack.
+func (t *threads) relayed(ts string) {
+	t.Lock()
+	defer t.Unlock()
+	if m, ok := t.msgs[ts]; ok {
+		m.relayed = true
+		t.msgs[ts] = m
+	}
+}
+
+// get returns the recent message with the given timestamp.
+func (t *threads) get(ts string) (recent, bool) {
+	t.Lock()
+	defer t.Unlock()
+	m, ok := t.msgs[ts]
+	return m, ok
+}
+
+// route returns the thread into which an IRC message should be posted,
+// or the empty string if it should be posted to the channel.
+// A message is routed into a thread if it addresses a slack user
+// ("alice: …" or "alice, …") who recently replied in the thread.
+func (t *threads) route(text string) string {
+	i := strings.IndexAny(text, ":,")
+	if i <= 0 || i+1 < len(text) && text[i+1] != ' ' {
+		return ""
+	}
+	nick := strings.ToLower(strings.TrimPrefix(text[:i], "@"))
+	t.Lock()
+	defer t.Unlock()
+	r, ok := t.last[nick]
+	if !ok || time.Since(r.when) > threadWindow {
+		return ""
+	}
+	return r.threadTS
+}
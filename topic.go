@@ -0,0 +1,39 @@
+package main
+
+import "github.com/velour/relay/bridge"
+
+// Topic synchronization modes for -topicsync.
+const (
+	topicNone       = "none"
+	topicBoth       = "both"
+	topicIRCToSlack = "irc-to-slack"
+	topicSlackToIRC = "slack-to-irc"
+)
+
+func validTopicMode(mode string) bool {
+	switch mode {
+	case topicNone, topicBoth, topicIRCToSlack, topicSlackToIRC:
+		return true
+	}
+	return false
+}
+
+// topicFilter returns a bridge filter
+// that relays topic changes between IRC endpoint ircEP
+// and the slack endpoint in the directions of the topic mode.
+func topicFilter(mode string, ircEP bridge.Endpoint) func(bridge.Endpoint, bridge.Event) bool {
+	return func(from bridge.Endpoint, e bridge.Event) bool {
+		if e.Kind != bridge.Topic {
+			return true
+		}
+		switch mode {
+		case topicBoth:
+			return true
+		case topicIRCToSlack:
+			return from == ircEP
+		case topicSlackToIRC:
+			return from != ircEP
+		}
+		return false
+	}
+}
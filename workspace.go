@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/velour/relay/slack"
+)
+
+// A workspace is a connection to a slack workspace
+// shared by all bridges on it.
+// It routes events from each slack channel to the endpoints of the channel.
+type workspace struct {
+	name   string
+	client *slack.Client
+	dir    *slack.Directory
+	// userID is the ID of the user whose messages are relayed,
+	// or empty if all bridges on the workspace are shared.
+	userID string
+
+	sync.Mutex
+	// routes are the endpoints of each slack channel,
+	// keyed by channel ID.
+	routes map[string][]*slackEndpoint
+}
+
+func dialSlack(name string, cfg *slackConfig) (w *workspace, err error) {
+	c, err := slack.NewClient(cfg.Token, slack.WithAPIURL(cfg.API))
+	if err != nil {
+		return nil, fmt.Errorf("slack failed to connect: %v", err)
+	}
+	c.PageSize = cfg.PageSize
+	c.OnStateChange(func(s slack.ConnState, err error) {
+		if err != nil {
+			log.Printf("slack %s %s: %v", name, s, err)
+		} else {
+			log.Printf("slack %s %s", name, s)
+		}
+	})
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	dir, err := slack.NewDirectory(c)
+	if err != nil {
+		return nil, fmt.Errorf("slack failed to get users list: %v", err)
+	}
+	var userID string
+	if cfg.Nick != "" {
+		u, ok := dir.UserByName(cfg.Nick)
+		if !ok {
+			return nil, fmt.Errorf("slack no user: %s", cfg.Nick)
+		}
+		userID = u.ID
+	}
+	if err := dir.LoadConversations(c, slack.PublicChannel, slack.PrivateChannel); err != nil {
+		return nil, fmt.Errorf("slack failed to get channels list: %v", err)
+	}
+	if err := dir.LoadUserGroups(c); err != nil {
+		log.Println("slack failed to get user groups:", err)
+	}
+	w = &workspace{
+		name:   name,
+		client: c,
+		dir:    dir,
+		userID: userID,
+		routes: make(map[string][]*slackEndpoint),
+	}
+	go w.run()
+	return w, nil
+}
+
+// join joins the slack channel with the given name or ID, if needed,
+// and routes its events to ep.
+// It returns the ID of the channel.
+func (w *workspace) join(channel string, ep *slackEndpoint) (string, error) {
+	name := strings.TrimPrefix(channel, "#")
+	conv, ok := w.dir.Conversation(name)
+	if !ok {
+		conv, _ = w.dir.ConversationByName(name)
+	}
+	if conv.ID != "" && !conv.IsMember && !conv.IsPrivate {
+		var err error
+		if conv, err = w.client.ConversationsJoin(conv.ID); err != nil {
+			return "", fmt.Errorf("slack failed to join channel: %v", err)
+		}
+		w.dir.PutConversation(conv)
+	}
+	if conv.ID == "" {
+		return "", fmt.Errorf("slack no channel: %s", channel)
+	}
+	ep.c = w.client
+	ep.dir = w.dir
+	ep.userID = w.userID
+	ep.channelID = conv.ID
+	w.Lock()
+	w.routes[conv.ID] = append(w.routes[conv.ID], ep)
+	w.Unlock()
+	go ep.run()
+	return conv.ID, nil
+}
+
+// leave stops routing events to ep.
+func (w *workspace) leave(ep *slackEndpoint) {
+	w.Lock()
+	defer w.Unlock()
+	eps := w.routes[ep.channelID]
+	for i, e := range eps {
+		if e == ep {
+			w.routes[ep.channelID] = append(eps[:i:i], eps[i+1:]...)
+			break
+		}
+	}
+}
+
+// run reads events from slack and routes them to the inboxes of endpoints,
+// so that it is not held up by an endpoint slow to handle them.
+// When the client is closed or fails, it disconnects all endpoints.
+func (w *workspace) run() {
+	defer func() {
+		w.Lock()
+		defer w.Unlock()
+		for id, eps := range w.routes {
+			for _, ep := range eps {
+				ep.disconnect()
+			}
+			delete(w.routes, id)
+		}
+	}()
+	for {
+		event, err := w.client.Next()
+		if err == slack.ErrClosed {
+			return
+		}
+		if _, ok := err.(*slack.UnmatchedReplyError); ok {
+			log.Println("slack:", err)
+			continue
+		}
+		if err != nil {
+			log.Printf("slack %s read error: %v", w.name, err)
+			w.client.Close()
+			return
+		}
+		var channel string
+		switch t, _ := event["type"].(string); t {
+		case "message":
+			channel, _ = event["channel"].(string)
+		case "reaction_added", "reaction_removed":
+			item, _ := event["item"].(map[string]interface{})
+			channel, _ = item["channel"].(string)
+		case "user_change", "team_join",
+			"channel_created", "channel_rename", "group_rename":
+			w.dir.Update(event)
+			continue
+		case "presence_change",
+			"user_typing":
+			// Silence noisy events.
+			continue
+		default:
+			log.Printf("slack event:\n%#v\n\n", event)
+			continue
+		}
+		w.Lock()
+		eps := w.routes[channel]
+		w.Unlock()
+		for _, ep := range eps {
+			ep.inbox.put(event)
+		}
+	}
+}